@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// path signature artifacts are written to & read from in the cosign
+	// container
+	signatureArtifactsPath = "/tmp/signatures"
+	// name of the file containing the digest a set of artifacts belong to
+	artifactReferenceFile   = "reference"
+	artifactSignatureFile   = "signature"
+	artifactPayloadFile     = "payload.json"
+	artifactCertificateFile = "certificate.pem"
+)
+
+// SignArtifacts will run cosign from the image, as defined by the cosignImage
+// parameter, to sign the given Container image digests without uploading
+// the signatures to the registry. The signatures are returned as a Directory
+// for review, to be attached later with Attach.
+//
+// The returned Directory contains a directory per digest with the layout:
+//
+//	<digest>/reference                the signed digest
+//	<digest>/key-<n>/signature        base64 encoded signature
+//	<digest>/key-<n>/payload.json     signed payload
+//	<digest>/key-<n>/certificate.pem  signing certificate (if any)
+//
+// where n is the index of the private key used (0 being privateKey)
+func (f *Cosign) SignArtifacts(
+	ctx context.Context,
+	// Cosign private key
+	privateKey *dagger.Secret,
+	// Cosign password, if the private key is encrypted with one
+	//+optional
+	password *dagger.Secret,
+	// additional Cosign private keys to sign with (e.g. during key rotation)
+	//+optional
+	additionalPrivateKeys []*dagger.Secret,
+	// passwords for the additional Cosign private keys, matched by index
+	//+optional
+	additionalPasswords []*dagger.Secret,
+	// Mount the private keys as secret files rather than environment variables
+	//+optional
+	//+default=false
+	privateKeyAsFile bool,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Container image digests to sign
+	digests ...string,
+) (*dagger.Directory, error) {
+	keys, err := signingKeys(
+		privateKey,
+		password,
+		additionalPrivateKeys,
+		additionalPasswords,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := f.pinCosignImage(
+		ctx,
+		cosignImageOrDefault(cosignImage),
+		*cosignUser,
+		false,
+		"",
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &signOpts{
+		keys:                  keys,
+		privateKeyAsFile:      privateKeyAsFile,
+		registryReferrersMode: referrersModeLegacy,
+		registryArgs:          regArgs,
+		dockerConfig:          dockerConfig,
+		cosignImage:           pinned.ref,
+		cosignUser:            *cosignUser,
+	}
+
+	artifacts := dag.Directory()
+	for _, d := range digests {
+		digestDir := artifactDirName(d)
+		artifacts = artifacts.WithNewFile(
+			fmt.Sprintf("%s/%s", digestDir, artifactReferenceFile),
+			d,
+		)
+
+		for i, key := range keys {
+			ctr, cmd, err := f.signCtr(ctx, opts, key, d)
+			if err != nil {
+				return nil, err
+			}
+
+			cmd = append(cmd,
+				"--upload=false",
+				"--output-signature",
+				fmt.Sprintf("%s/%s", signatureArtifactsPath, artifactSignatureFile),
+				"--output-payload",
+				fmt.Sprintf("%s/%s", signatureArtifactsPath, artifactPayloadFile),
+				"--output-certificate",
+				fmt.Sprintf("%s/%s", signatureArtifactsPath, artifactCertificateFile),
+			)
+
+			signed := ctr.
+				WithDirectory(
+					signatureArtifactsPath,
+					dag.Directory(),
+					dagger.ContainerWithDirectoryOpts{Owner: *cosignUser},
+				).
+				WithExec(cmd).
+				Directory(signatureArtifactsPath)
+
+			artifacts = artifacts.WithDirectory(
+				fmt.Sprintf("%s/key-%d", digestDir, i),
+				signed,
+			)
+		}
+	}
+
+	return artifacts, nil
+}
+
+// Attach will run cosign from the image, as defined by the cosignImage
+// parameter, to attach signatures previously exported with SignArtifacts
+// to the digests they were signed for
+//
+// See https://github.com/sigstore/cosign/blob/main/doc/cosign_attach_signature.md
+func (f *Cosign) Attach(
+	ctx context.Context,
+	// signature artifacts as returned by SignArtifacts
+	artifacts *dagger.Directory,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+) ([]string, error) {
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	digestDirs, err := artifacts.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := f.pinCosignImage(
+		ctx,
+		cosignImageOrDefault(cosignImage),
+		*cosignUser,
+		false,
+		"",
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr := f.
+		cosignCtr(pinned.ref, *cosignUser, dockerConfig).
+		WithMountedDirectory(signatureArtifactsPath, artifacts)
+
+	stdouts := []string{}
+	for _, digestDir := range digestDirs {
+		digestDir = strings.TrimSuffix(digestDir, "/")
+		digest, err := artifacts.
+			File(fmt.Sprintf("%s/%s", digestDir, artifactReferenceFile)).
+			Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error reading reference for '%s': %w", digestDir, err)
+		}
+		digest = strings.TrimSpace(digest)
+
+		keyDirs, err := artifacts.Directory(digestDir).Entries(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, keyDir := range keyDirs {
+			keyDir = strings.TrimSuffix(keyDir, "/")
+			if !strings.HasPrefix(keyDir, "key-") {
+				continue
+			}
+
+			dir := fmt.Sprintf("%s/%s", digestDir, keyDir)
+			files, err := artifacts.Directory(dir).Entries(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			path := fmt.Sprintf("%s/%s", signatureArtifactsPath, dir)
+			cmd := []string{
+				"cosign", "attach", "signature", digest,
+				"--signature", fmt.Sprintf("%s/%s", path, artifactSignatureFile),
+				"--payload", fmt.Sprintf("%s/%s", path, artifactPayloadFile),
+			}
+			if slices.Contains(files, artifactCertificateFile) {
+				cmd = append(cmd,
+					"--certificate",
+					fmt.Sprintf("%s/%s", path, artifactCertificateFile),
+				)
+			}
+			cmd = append(cmd, regArgs...)
+
+			stdout, err := ctr.WithExec(cmd).Stdout(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			stdouts = append(stdouts, stdout)
+		}
+	}
+
+	return stdouts, nil
+}
+
+// artifactDirName returns a file system safe directory name for the given
+// digest, e.g. ghcr.io/org/image@sha256:abc -> ghcr.io_org_image@sha256_abc
+func artifactDirName(digest string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(digest)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+)
+
+// attest runs `cosign attest` with each signing key to attach the given
+// predicate, of the given predicate type, to the given digest returning the
+// output of each attestation
+//
+// See https://github.com/sigstore/cosign/blob/main/doc/cosign_attest.md
+func (f *Cosign) attest(
+	ctx context.Context,
+	// options shared by each cosign invocation
+	opts *signOpts,
+	// in-toto predicate
+	predicate *dagger.File,
+	// predicate type (e.g. vuln, spdxjson, cyclonedx)
+	predicateType string,
+	// Container image digest to attest
+	digest string,
+) ([]string, error) {
+	const predicatePath = "/tmp/predicate.json"
+
+	stdouts := []string{}
+	for _, key := range opts.keys {
+		ctr, keyRef, err := f.signingKeyCtr(ctx, opts, key)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd := []string{
+			"cosign", "attest", digest,
+			"--key", keyRef,
+			"--predicate", predicatePath,
+			"--type", predicateType,
+		}
+		cmd = append(cmd, opts.registryArgs...)
+		cmd = append(cmd, referrersModeWriteArgs(opts.registryReferrersMode)...)
+
+		stdout, err := stdoutWithRetry(
+			ctx,
+			opts.retry,
+			ctr.WithMountedFile(predicatePath, predicate),
+			cmd,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		stdouts = append(stdouts, stdout)
+	}
+
+	return stdouts, nil
+}
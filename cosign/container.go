@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"fmt"
+)
+
+// cosignCtr returns a Container from the given cosign image, as the given
+// user, with the Docker config (if any) mounted prior to any cosign
+// invocation
+func (f *Cosign) cosignCtr(
+	// Cosign container image
+	cosignImage string,
+	// Cosign container image user
+	cosignUser string,
+	// Docker config
+	dockerConfig *dagger.File,
+) *dagger.Container {
+	ctr := dag.
+		Container().
+		From(cosignImage).
+		WithUser(cosignUser).
+		WithEnvVariable("COSIGN_YES", "true")
+
+	if dockerConfig != nil {
+		ctr = ctr.WithMountedFile(
+			fmt.Sprintf("%s/.docker/config.json", homeDir(cosignUser)),
+			dockerConfig,
+			dagger.ContainerWithMountedFileOpts{Owner: cosignUser})
+	}
+
+	return ctr
+}
+
+// homeDir returns the home directory of the given cosign container user
+func homeDir(user string) string {
+	if user == "root" {
+		return "/root"
+	}
+
+	return fmt.Sprintf("/home/%s", user)
+}
+
+// registryArgs returns the cosign registry authentication arguments if both
+// the registry username and password are provided
+func registryArgs(
+	ctx context.Context,
+	// registry username
+	registryUsername *string,
+	// registry password
+	registryPassword *dagger.Secret,
+) ([]string, error) {
+	if registryUsername == nil || registryPassword == nil {
+		return nil, nil
+	}
+
+	pwd, err := registryPassword.Plaintext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		"--registry-username",
+		*registryUsername,
+		"--registry-password",
+		pwd,
+	}, nil
+}
+
+const (
+	referrersModeLegacy = "legacy"
+	referrersModeOci11  = "oci-1-1"
+)
+
+// ctrWithReferrersMode returns the given Container configured for the given
+// registry referrers mode, one of: legacy, oci-1-1
+//
+// The OCI 1.1 referrers API is experimental in cosign and requires
+// COSIGN_EXPERIMENTAL to be set
+func ctrWithReferrersMode(
+	ctr *dagger.Container,
+	// registry referrers mode
+	mode string,
+) (*dagger.Container, error) {
+	switch mode {
+	case referrersModeLegacy:
+		return ctr, nil
+	case referrersModeOci11:
+		return ctr.WithEnvVariable("COSIGN_EXPERIMENTAL", "1"), nil
+	}
+
+	return nil, fmt.Errorf(
+		"unsupported registry referrers mode '%s', must be one of: %s, %s",
+		mode,
+		referrersModeLegacy,
+		referrersModeOci11,
+	)
+}
+
+// referrersModeWriteArgs returns the arguments for cosign commands which
+// store signatures or attestations (i.e. sign, attest) for the given registry
+// referrers mode
+func referrersModeWriteArgs(mode string) []string {
+	if mode == referrersModeOci11 {
+		return []string{fmt.Sprintf("--registry-referrers-mode=%s", mode)}
+	}
+
+	return nil
+}
+
+// referrersModeReadArgs returns the arguments for cosign commands which
+// discover signatures or attestations (i.e. verify, tree) for the given
+// registry referrers mode
+func referrersModeReadArgs(mode string) []string {
+	if mode == referrersModeOci11 {
+		return []string{"--experimental-oci11"}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"fmt"
+)
+
+// Countersign will run cosign from the image, as defined by the cosignImage
+// parameter, to verify the given Container image digests are signed by the
+// required public key (e.g. the build signature) and only then sign them with
+// the given private key and approval annotations (e.g. a QA approval)
+//
+// An error is returned, prior to signing any digest, if any digest is not
+// signed by the required public key
+func (f *Cosign) Countersign(
+	ctx context.Context,
+	// Cosign public key the digests must already be signed by
+	requiredPublicKey *dagger.File,
+	// Cosign private key to countersign with
+	privateKey *dagger.Secret,
+	// Cosign password, if the private key is encrypted with one
+	//+optional
+	password *dagger.Secret,
+	// Mount the private key as a secret file rather than an environment
+	// variable
+	//+optional
+	//+default=false
+	privateKeyAsFile bool,
+	// Approval annotations (key=value) to add to the countersignature
+	//+optional
+	annotations []string,
+	// Registry referrers mode signatures are stored with, one of:
+	// legacy (sha256-<digest>.sig tags), oci-1-1 (OCI 1.1 referrers API)
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Number of times to retry signing a digest after a transient registry or
+	// transparency log failure (e.g. 5xx, rate limiting)
+	//+optional
+	//+default=3
+	retries int,
+	// Backoff before the first retry, doubled for each subsequent retry
+	//+optional
+	//+default="2s"
+	retryBackoff *string,
+	// Container image digests to countersign
+	digests ...string,
+) ([]*SignResult, error) {
+	retry, err := newRetryOpts(retries, *retryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := f.pinCosignImage(ctx, cosignImageOrDefault(cosignImage), *cosignUser, false, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+	verifyArgs := append(regArgs, referrersModeReadArgs(*registryReferrersMode)...)
+
+	for _, d := range digests {
+		ctr, err := ctrWithReferrersMode(
+			f.cosignCtr(image.ref, *cosignUser, dockerConfig),
+			*registryReferrersMode,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		verified, err := f.verifyWithKey(ctx, ctr, requiredPublicKey, verifyArgs, d)
+		if err != nil {
+			return nil, err
+		}
+		if !verified {
+			return nil, fmt.Errorf(
+				"unable to countersign '%s': not signed by the required public key",
+				d,
+			)
+		}
+	}
+
+	s, err := f.newSigner(ctx, &signerOpts{
+		signer:                signerCosign,
+		privateKey:            privateKey,
+		password:              password,
+		privateKeyAsFile:      privateKeyAsFile,
+		annotations:           annotations,
+		registryReferrersMode: *registryReferrersMode,
+		registryUsername:      registryUsername,
+		registryPassword:      registryPassword,
+		dockerConfig:          dockerConfig,
+		cosignImage:           image.ref,
+		cosignUser:            *cosignUser,
+		retry:                 retry,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*SignResult{}
+	for _, d := range digests {
+		signed, err := s.sign(ctx, d)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range signed {
+			r.CosignImage = image.ref
+			r.CosignVersion = image.version
+		}
+
+		results = append(results, signed...)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// default cosign container image, not yet pinned by digest: renovate
+	// (pinDigests) adds the digest & keeps it current, until then the image is
+	// resolved to its digest per call only (see pinCosignImage)
+	// renovate: datasource=docker depName=chainguard/cosign
+	defaultCosignImage = "chainguard/cosign:latest"
+	// image used to verify the signature of the cosign container image, from
+	// a separate (sigstore) release pipeline, so the cosign image does not
+	// verify itself. Pinned by version only until renovate adds its digest
+	// (pinDigests)
+	// renovate: datasource=docker depName=ghcr.io/sigstore/cosign/cosign
+	cosignVerifierImage = "ghcr.io/sigstore/cosign/cosign:v2.4.1"
+	// user of the cosign verifier image
+	cosignVerifierUser = "nonroot"
+)
+
+// cosignImageOrDefault returns the given cosign image, or the default cosign
+// image if not set
+func cosignImageOrDefault(image *string) string {
+	if image == nil || *image == "" {
+		return defaultCosignImage
+	}
+
+	return *image
+}
+
+// pinnedImage represents the cosign container image used for signing,
+// pinned by digest
+type pinnedImage struct {
+	// image reference pinned by digest
+	ref string
+	// cosign version (e.g. v2.4.1)
+	version string
+}
+
+// pinCosignImage resolves the given cosign image to its digest, so every
+// invocation uses the exact same image, and determines the cosign version.
+//
+// If verify is true the signature of the pinned image is verified against
+// the given certificate identity & issuer prior to use, with the separately
+// pinned cosignVerifierImage rather than the image itself.
+func (f *Cosign) pinCosignImage(
+	ctx context.Context,
+	// Cosign container image
+	image string,
+	// Cosign container image user
+	user string,
+	// if true the cosign image signature will be verified
+	verify bool,
+	// certificate identity expected to have signed the cosign image
+	identity string,
+	// certificate OIDC issuer expected to have issued the signing certificate
+	issuer string,
+) (*pinnedImage, error) {
+	ref, err := dag.Container().From(image).ImageRef(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving cosign image '%s': %w", image, err)
+	}
+	if !strings.Contains(ref, "@sha256:") {
+		return nil, fmt.Errorf("unable to pin cosign image '%s' by digest: %s", image, ref)
+	}
+
+	if verify {
+		_, err := f.cosignCtr(cosignVerifierImage, cosignVerifierUser, nil).
+			WithExec([]string{
+				"cosign", "verify", ref,
+				"--certificate-identity", identity,
+				"--certificate-oidc-issuer", issuer,
+			}).
+			Sync(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying cosign image '%s': %w", ref, err)
+		}
+	}
+
+	stdout, err := f.cosignCtr(ref, user, nil).
+		WithExec([]string{"cosign", "version", "--json"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cosign version of '%s': %w", ref, err)
+	}
+
+	version := struct {
+		GitVersion string `json:"gitVersion"`
+	}{}
+	if err := json.Unmarshal([]byte(stdout), &version); err != nil {
+		return nil, fmt.Errorf("error parsing cosign version of '%s': %w", ref, err)
+	}
+
+	return &pinnedImage{ref: ref, version: version.GitVersion}, nil
+}
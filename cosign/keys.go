@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"dagger/cosign/internal/dagger"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"slices"
+)
+
+const (
+	// path the private key is mounted at when provided as a file
+	privateKeyPath = "/run/secrets/cosign.key"
+	// path prefix of unencrypted private keys imported with
+	// `cosign import-key-pair`
+	importedKeyPrefix = "/tmp/cosign-import"
+)
+
+// cosign encrypted private key PEM block types, any other private key type is
+// treated as unencrypted and imported prior to use
+var encryptedKeyPemTypes = []string{
+	"ENCRYPTED SIGSTORE PRIVATE KEY",
+	"ENCRYPTED COSIGN PRIVATE KEY",
+}
+
+// signingKey represents a cosign private key and its (optional) password
+type signingKey struct {
+	privateKey *dagger.Secret
+	password   *dagger.Secret
+}
+
+// signingKeys returns the signing keys from the given primary private key &
+// password followed by any additional private keys and their passwords,
+// matched by index
+func signingKeys(
+	// Cosign private key
+	privateKey *dagger.Secret,
+	// Cosign password
+	password *dagger.Secret,
+	// additional Cosign private keys
+	additionalPrivateKeys []*dagger.Secret,
+	// additional Cosign passwords, if any
+	additionalPasswords []*dagger.Secret,
+) ([]*signingKey, error) {
+	if len(additionalPasswords) > 0 &&
+		len(additionalPrivateKeys) != len(additionalPasswords) {
+		return nil, fmt.Errorf(
+			"number of additional private keys (%d) and passwords (%d) must match",
+			len(additionalPrivateKeys),
+			len(additionalPasswords),
+		)
+	}
+
+	keys := []*signingKey{{privateKey: privateKey, password: password}}
+	for i, k := range additionalPrivateKeys {
+		key := &signingKey{privateKey: k}
+		if len(additionalPasswords) > 0 {
+			key.password = additionalPasswords[i]
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// encrypted returns true if the signing key is a cosign encrypted private key
+func (k *signingKey) encrypted(ctx context.Context) (bool, error) {
+	key, err := k.privateKey.Plaintext(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return false, fmt.Errorf("private key is not PEM encoded")
+	}
+
+	return slices.Contains(encryptedKeyPemTypes, block.Type), nil
+}
+
+// ctrWithSigningKey returns the given Container with the signing key and
+// password set as cosign expects, along with the reference to pass to `--key`
+//
+// The key is passed as an environment variable unless asFile is true, in which
+// case it is mounted as a secret file. Unencrypted keys are always mounted
+// and imported with `cosign import-key-pair` as cosign only signs with its
+// own encrypted key format.
+func ctrWithSigningKey(
+	ctx context.Context,
+	// Cosign container
+	ctr *dagger.Container,
+	// Cosign container image user
+	cosignUser string,
+	// signing key
+	key *signingKey,
+	// if true, the key will be mounted as a secret file
+	asFile bool,
+) (*dagger.Container, string, error) {
+	if key.password != nil {
+		ctr = ctr.WithSecretVariable("COSIGN_PASSWORD", key.password)
+	} else {
+		ctr = ctr.WithEnvVariable("COSIGN_PASSWORD", "")
+	}
+
+	encrypted, err := key.encrypted(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !encrypted || asFile {
+		ctr = ctr.WithMountedSecret(
+			privateKeyPath,
+			key.privateKey,
+			dagger.ContainerWithMountedSecretOpts{Owner: cosignUser},
+		)
+	}
+
+	switch {
+	case !encrypted:
+		ctr = ctr.WithExec([]string{
+			"cosign", "import-key-pair",
+			"--key", privateKeyPath,
+			"--output-key-prefix", importedKeyPrefix,
+		})
+
+		return ctr, fmt.Sprintf("%s.key", importedKeyPrefix), nil
+	case asFile:
+		return ctr, privateKeyPath, nil
+	}
+
+	return ctr.WithSecretVariable("COSIGN_PRIVATE_KEY", key.privateKey),
+		"env://COSIGN_PRIVATE_KEY",
+		nil
+}
+
+// publicKeyFingerprint returns the SHA-256 fingerprint of the given PEM
+// encoded public key (i.e. of its DER encoded SubjectPublicKeyInfo)
+func publicKeyFingerprint(publicKey string) (string, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return "", fmt.Errorf("public key is not PEM encoded")
+	}
+
+	return fingerprint(block.Bytes), nil
+}
+
+// fingerprint returns the SHA-256 fingerprint of the given DER bytes
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	return fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:]))
+}
+
+// PublicKey will run cosign from the image, as defined by the cosignImage
+// parameter, to derive the public key from the given private key
+//
+// See https://github.com/sigstore/cosign/blob/main/doc/cosign_public-key.md
+func (f *Cosign) PublicKey(
+	ctx context.Context,
+	// Cosign private key
+	privateKey *dagger.Secret,
+	// Cosign password, if the private key is encrypted with one
+	//+optional
+	password *dagger.Secret,
+	// Mount the private key as a secret file rather than an environment
+	// variable
+	//+optional
+	//+default=false
+	privateKeyAsFile bool,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+) (*dagger.File, error) {
+	const publicKeyPath = "/tmp/cosign.pub"
+
+	pinned, err := f.pinCosignImage(
+		ctx,
+		cosignImageOrDefault(cosignImage),
+		*cosignUser,
+		false,
+		"",
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, keyRef, err := ctrWithSigningKey(
+		ctx,
+		f.cosignCtr(pinned.ref, *cosignUser, nil),
+		*cosignUser,
+		&signingKey{privateKey: privateKey, password: password},
+		privateKeyAsFile,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctr.
+		WithExec([]string{
+			"cosign", "public-key",
+			"--key", keyRef,
+			"--outfile", publicKeyPath,
+		}).
+		File(publicKeyPath), nil
+}
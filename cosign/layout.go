@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"dagger/cosign/internal/dagger"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// OCI layout annotation cosign uses to identify the kind of a descriptor
+	// in the index (see `cosign save`)
+	layoutKindAnnotation = "kind"
+	layoutKindImage      = "dev.cosignproject.cosign/image"
+	layoutKindImageIndex = "dev.cosignproject.cosign/imageIndex"
+	layoutKindSignatures = "dev.cosignproject.cosign/sigs"
+	layoutKindAtts       = "dev.cosignproject.cosign/atts"
+
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType       = "application/vnd.oci.image.index.v1+json"
+	dockerListMediaType     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociConfigMediaType      = "application/vnd.oci.image.config.v1+json"
+	simpleSigningMediaType  = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureAnnotation     = "dev.cosignproject.cosign/signature"
+	certificateAnnotation   = "dev.sigstore.cosign/certificate"
+	bundleAnnotation        = "dev.sigstore.cosign/bundle"
+	simpleSigningSignedType = "cosign container image signature"
+)
+
+// ociDescriptor represents an OCI content descriptor
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int               `json:"size"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    json.RawMessage   `json:"platform,omitempty"`
+}
+
+// ociIndex represents an OCI image index (index.json)
+type ociIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []*ociDescriptor  `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest represents an OCI image manifest
+type ociManifest struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	MediaType     string           `json:"mediaType"`
+	Config        *ociDescriptor   `json:"config"`
+	Layers        []*ociDescriptor `json:"layers"`
+}
+
+// simpleSigningPayload represents the cosign simple signing payload
+//
+// See https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// signBlobBundle represents the (legacy) bundle written by
+// `cosign sign-blob --bundle`
+type signBlobBundle struct {
+	Base64Signature string          `json:"base64Signature"`
+	Cert            string          `json:"cert"`
+	RekorBundle     json.RawMessage `json:"rekorBundle"`
+}
+
+// SignLayout will run cosign from the image, as defined by the cosignImage
+// parameter, to sign the image in the given OCI layout Directory without a
+// registry, adding the signature manifest to the layout as `cosign save`
+// does. The signatures travel with the layout and are pushed along with it
+// (e.g. `cosign load`), and can be verified with VerifyOffline.
+//
+// The layout must contain a single image (manifest or index), which is
+// signed with the given private key and any additional private keys
+func (f *Cosign) SignLayout(
+	ctx context.Context,
+	// OCI layout containing the image to sign
+	layout *dagger.Directory,
+	// Image repository the image will be pushed to, signed as the
+	// docker-reference (e.g. ghcr.io/org/image)
+	reference string,
+	// Cosign private key
+	privateKey *dagger.Secret,
+	// Cosign password, if the private key is encrypted with one
+	//+optional
+	password *dagger.Secret,
+	// additional Cosign private keys to sign with (e.g. during key rotation)
+	//+optional
+	additionalPrivateKeys []*dagger.Secret,
+	// passwords for the additional Cosign private keys, matched by index
+	//+optional
+	additionalPasswords []*dagger.Secret,
+	// Mount the private keys as secret files rather than environment variables
+	//+optional
+	//+default=false
+	privateKeyAsFile bool,
+	// Annotations (key=value) to add to the signatures
+	//+optional
+	annotations []string,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+) (*dagger.Directory, error) {
+	const (
+		payloadPath = "/tmp/payload.json"
+		outPath     = "/tmp/out"
+	)
+
+	keys, err := signingKeys(
+		privateKey,
+		password,
+		additionalPrivateKeys,
+		additionalPasswords,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	optional, err := annotationsMap(annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	indexJson, err := layout.File("index.json").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading OCI layout index.json: %w", err)
+	}
+	index := &ociIndex{}
+	if err := json.Unmarshal([]byte(indexJson), index); err != nil {
+		return nil, fmt.Errorf("error parsing OCI layout index.json: %w", err)
+	}
+
+	image, signatures := (*ociDescriptor)(nil), (*ociDescriptor)(nil)
+	for _, m := range index.Manifests {
+		switch m.Annotations[layoutKindAnnotation] {
+		case layoutKindSignatures:
+			signatures = m
+		case layoutKindAtts:
+		default:
+			if image != nil {
+				return nil, fmt.Errorf(
+					"OCI layout must contain a single image, found: %s, %s",
+					image.Digest,
+					m.Digest,
+				)
+			}
+			image = m
+		}
+	}
+	if image == nil {
+		return nil, fmt.Errorf("OCI layout does not contain an image")
+	}
+
+	payload := simpleSigningPayload{Optional: optional}
+	payload.Critical.Identity.DockerReference = reference
+	payload.Critical.Image.DockerManifestDigest = image.Digest
+	payload.Critical.Type = simpleSigningSignedType
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering signature payload: %w", err)
+	}
+	payloadDigest, payloadHex := sha256Digest(payloadJson)
+
+	// extend any existing signatures rather than replacing them
+	manifest := &ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Layers:        []*ociDescriptor{},
+	}
+	if signatures != nil {
+		manifestJson, err := layoutBlob(ctx, layout, signatures.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(manifestJson), manifest); err != nil {
+			return nil, fmt.Errorf("error parsing OCI layout signatures manifest: %w", err)
+		}
+	}
+
+	pinned, err := f.pinCosignImage(
+		ctx,
+		cosignImageOrDefault(cosignImage),
+		*cosignUser,
+		false,
+		"",
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &signOpts{
+		keys:                  keys,
+		privateKeyAsFile:      privateKeyAsFile,
+		registryReferrersMode: referrersModeLegacy,
+		cosignImage:           pinned.ref,
+		cosignUser:            *cosignUser,
+	}
+	for _, key := range keys {
+		ctr, keyRef, err := f.signingKeyCtr(ctx, opts, key)
+		if err != nil {
+			return nil, err
+		}
+
+		bundleJson, err := ctr.
+			WithNewFile(payloadPath, string(payloadJson)).
+			WithDirectory(
+				outPath,
+				dag.Directory(),
+				dagger.ContainerWithDirectoryOpts{Owner: *cosignUser},
+			).
+			WithExec([]string{
+				"cosign", "sign-blob", payloadPath,
+				"--key", keyRef,
+				"--bundle", fmt.Sprintf("%s/bundle.json", outPath),
+				// the legacy bundle carries the signature cosign save stores
+				"--new-bundle-format=false",
+			}).
+			File(fmt.Sprintf("%s/bundle.json", outPath)).
+			Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		bundle := signBlobBundle{}
+		if err := json.Unmarshal([]byte(bundleJson), &bundle); err != nil {
+			return nil, fmt.Errorf("error parsing cosign sign-blob bundle: %w", err)
+		}
+		if bundle.Base64Signature == "" {
+			return nil, fmt.Errorf("cosign sign-blob bundle does not contain a signature")
+		}
+
+		layer := &ociDescriptor{
+			MediaType: simpleSigningMediaType,
+			Size:      len(payloadJson),
+			Digest:    payloadDigest,
+			Annotations: map[string]string{
+				signatureAnnotation: bundle.Base64Signature,
+			},
+		}
+		if bundle.Cert != "" {
+			layer.Annotations[certificateAnnotation] = bundle.Cert
+		}
+		if len(bundle.RekorBundle) > 0 && string(bundle.RekorBundle) != "null" {
+			layer.Annotations[bundleAnnotation] = string(bundle.RekorBundle)
+		}
+		manifest.Layers = append(manifest.Layers, layer)
+	}
+
+	diffIds := []string{}
+	for _, l := range manifest.Layers {
+		diffIds = append(diffIds, l.Digest)
+	}
+	configJson, err := json.Marshal(map[string]any{
+		"architecture": "",
+		"os":           "",
+		"config":       map[string]any{},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": diffIds},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error rendering signatures config: %w", err)
+	}
+	configDigest, configHex := sha256Digest(configJson)
+	manifest.Config = &ociDescriptor{
+		MediaType: ociConfigMediaType,
+		Size:      len(configJson),
+		Digest:    configDigest,
+	}
+
+	manifestJson, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering signatures manifest: %w", err)
+	}
+	manifestDigest, manifestHex := sha256Digest(manifestJson)
+
+	if image.Annotations == nil {
+		image.Annotations = map[string]string{}
+	}
+	image.Annotations[layoutKindAnnotation] = layoutKindImage
+	if image.MediaType == ociIndexMediaType || image.MediaType == dockerListMediaType {
+		image.Annotations[layoutKindAnnotation] = layoutKindImageIndex
+	}
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(m *ociDescriptor) bool {
+		return m == signatures
+	})
+	index.Manifests = append(index.Manifests, &ociDescriptor{
+		MediaType:   ociManifestMediaType,
+		Size:        len(manifestJson),
+		Digest:      manifestDigest,
+		Annotations: map[string]string{layoutKindAnnotation: layoutKindSignatures},
+	})
+	indexOut, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering OCI layout index.json: %w", err)
+	}
+
+	return layout.
+		WithNewFile(fmt.Sprintf("blobs/sha256/%s", payloadHex), string(payloadJson)).
+		WithNewFile(fmt.Sprintf("blobs/sha256/%s", configHex), string(configJson)).
+		WithNewFile(fmt.Sprintf("blobs/sha256/%s", manifestHex), string(manifestJson)).
+		WithNewFile("index.json", string(indexOut)), nil
+}
+
+// layoutBlob returns the contents of the blob with the given digest in the
+// given OCI layout
+func layoutBlob(
+	ctx context.Context,
+	// OCI layout
+	layout *dagger.Directory,
+	// blob digest (e.g. sha256:<hex>)
+	digest string,
+) (string, error) {
+	algorithm, encoded, found := strings.Cut(digest, ":")
+	if !found {
+		return "", fmt.Errorf("invalid digest '%s'", digest)
+	}
+
+	contents, err := layout.File(fmt.Sprintf("blobs/%s/%s", algorithm, encoded)).Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error reading OCI layout blob '%s': %w", digest, err)
+	}
+
+	return contents, nil
+}
+
+// sha256Digest returns the OCI digest (sha256:<hex>) and hex of the given data
+func sha256Digest(data []byte) (string, string) {
+	sum := sha256.Sum256(data)
+	h := hex.EncodeToString(sum[:])
+
+	return fmt.Sprintf("sha256:%s", h), h
+}
+
+// annotationsMap returns the given key=value annotations as a map, or nil if
+// there are none
+func annotationsMap(annotations []string) (map[string]string, error) {
+	if len(annotations) == 0 {
+		return nil, nil
+	}
+
+	m := map[string]string{}
+	for _, a := range annotations {
+		k, v, found := strings.Cut(a, "=")
+		if !found {
+			return nil, fmt.Errorf("annotation '%s' must be in the form key=value", a)
+		}
+		m[k] = v
+	}
+
+	return m, nil
+}
@@ -9,18 +9,96 @@ import (
 // Cosign represents the cosign Dagger module type
 type Cosign struct{}
 
+// SignResult represents the result of signing a container image digest with
+// a single private key
+type SignResult struct {
+	// Container image digest signed
+	Digest string
+	// Signer backend used, one of: cosign, notation
+	Signer string
+	// Index of the private key used (0 being privateKey)
+	Key int
+	// SHA-256 fingerprint of the public key of the private key used
+	KeyFingerprint string
+	// Signing certificate subject (notation signer only)
+	Identity string
+	// Rekor transparency log index of the signature, if uploaded
+	RekorLogIndex string
+	// Annotations (key=value) added to the signature
+	Annotations []string
+	// Time the digest was signed (RFC 3339)
+	SignedAt string
+	// Signer output
+	Output string
+	// Cosign container image used, pinned by digest (cosign signer only)
+	CosignImage string
+	// Cosign version used (cosign signer only)
+	CosignVersion string
+}
+
 // Sign will run cosign from the image, as defined by the cosignImage
 // parameter, to sign the given Container image digests
 //
+// Alternatively, with signer set to notation, the digests are signed with
+// Notation (Notary v2), from the notationImage, using the private key and
+// the notationCertificate
+//
+// Each digest is signed with the given private key and any additional private
+// keys (e.g. during key rotation), returning the result of each signing in
+// the order of digests then keys
+//
+// The cosign image is resolved to its digest prior to signing so each digest
+// is signed with the exact same image, optionally verifying its signature
+// first (see verifyCosignImage)
+//
+// Private keys may be cosign encrypted keys or unencrypted PEM keys (imported
+// with `cosign import-key-pair` prior to signing), with or without a password
+//
 // Note: keyless signing not supported as-is
 //
 // See https://edu.chainguard.dev/open-source/sigstore/cosign/an-introduction-to-cosign/
 func (f *Cosign) Sign(
 	ctx context.Context,
-	// Cosign private key
-	privateKey dagger.Secret,
-	// Cosign password
-	password dagger.Secret,
+	// Cosign private key (or Notation private key, PEM encoded & unencrypted,
+	// if the signer is notation)
+	privateKey *dagger.Secret,
+	// Cosign password, if the private key is encrypted with one
+	//+optional
+	password *dagger.Secret,
+	// Annotations (key=value) to add to the signatures
+	//+optional
+	annotations []string,
+	// Signer backend, one of: cosign, notation
+	//+optional
+	//+default="cosign"
+	signer *string,
+	// Notation signing certificate (chain), PEM encoded, required if the
+	// signer is notation
+	//+optional
+	notationCertificate *dagger.File,
+	// Notation signature envelope format, one of: jws, cose
+	//+optional
+	//+default="jws"
+	notationSignatureFormat *string,
+	// Notation container image (ideally digest-pinned), required if the
+	// signer is notation
+	//+optional
+	notationImage *string,
+	// additional Cosign private keys to sign with (e.g. during key rotation)
+	//+optional
+	additionalPrivateKeys []*dagger.Secret,
+	// passwords for the additional Cosign private keys, matched by index
+	//+optional
+	additionalPasswords []*dagger.Secret,
+	// Mount the private keys as secret files rather than environment variables
+	//+optional
+	//+default=false
+	privateKeyAsFile bool,
+	// Registry referrers mode to store signatures with, one of:
+	// legacy (sha256-<digest>.sig tags), oci-1-1 (OCI 1.1 referrers API)
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
 	// registry username
 	//+optional
 	registryUsername *string,
@@ -30,57 +108,174 @@ func (f *Cosign) Sign(
 	// Docker config
 	//+optional
 	dockerConfig *dagger.File,
-	// Cosign container image
+	// Cosign container image (defaults to chainguard/cosign)
 	//+optional
-	//+default="chainguard/cosign:latest"
 	cosignImage *string,
 	// Cosign container image user
 	//+optional
 	//+default="nonroot"
 	cosignUser *string,
+	// Verify the signature of the cosign container image prior to use
+	//+optional
+	//+default=false
+	verifyCosignImage bool,
+	// Certificate identity expected to have signed the cosign container image
+	//+optional
+	//+default="https://github.com/chainguard-images/images/.github/workflows/release.yaml@refs/heads/main"
+	cosignImageIdentity *string,
+	// Certificate OIDC issuer expected to have issued the cosign container
+	// image signing certificate
+	//+optional
+	//+default="https://token.actions.githubusercontent.com"
+	cosignImageOidcIssuer *string,
+	// Number of times to retry signing a digest after a transient registry or
+	// transparency log failure (e.g. 5xx, rate limiting)
+	//+optional
+	//+default=3
+	retries int,
+	// Backoff before the first retry, doubled for each subsequent retry
+	//+optional
+	//+default="2s"
+	retryBackoff *string,
 	// Container image digests to sign
 	digests ...string,
-) ([]string, error) {
-	stdouts := []string{}
-	for _, d := range digests {
-		cmd := []string{"cosign", "sign", d, "--key", "env://COSIGN_PRIVATE_KEY"}
-		if registryUsername != nil && registryPassword != nil {
-			pwd, err := registryPassword.Plaintext(ctx)
-			if err != nil {
-				return nil, err
-			}
+) ([]*SignResult, error) {
+	retry, err := newRetryOpts(retries, *retryBackoff)
+	if err != nil {
+		return nil, err
+	}
 
-			cmd = append(
-				cmd,
-				"--registry-username",
-				*registryUsername,
-				"--registry-password",
-				pwd,
-			)
-		}
-		cosign := dag.
-			Container().
-			From(*cosignImage).
-			WithUser(*cosignUser).
-			WithEnvVariable("COSIGN_YES", "true").
-			WithSecretVariable("COSIGN_PASSWORD", &password).
-			WithSecretVariable("COSIGN_PRIVATE_KEY", &privateKey).
-			WithExec(cmd)
-
-		if dockerConfig != nil {
-			cosign = cosign.WithMountedFile(
-				"/home/nonroot/.docker/config.json",
-				dockerConfig,
-				dagger.ContainerWithMountedFileOpts{Owner: *cosignUser})
+	image := &pinnedImage{ref: cosignImageOrDefault(cosignImage)}
+	if *signer == signerCosign {
+		image, err = f.pinCosignImage(
+			ctx,
+			cosignImageOrDefault(cosignImage),
+			*cosignUser,
+			verifyCosignImage,
+			*cosignImageIdentity,
+			*cosignImageOidcIssuer,
+		)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		stdout, err := cosign.Stdout(ctx)
+	s, err := f.newSigner(ctx, &signerOpts{
+		signer:                  *signer,
+		privateKey:              privateKey,
+		password:                password,
+		additionalPrivateKeys:   additionalPrivateKeys,
+		additionalPasswords:     additionalPasswords,
+		privateKeyAsFile:        privateKeyAsFile,
+		annotations:             annotations,
+		notationCertificate:     notationCertificate,
+		notationSignatureFormat: *notationSignatureFormat,
+		notationImage:           notationImage,
+		registryReferrersMode:   *registryReferrersMode,
+		registryUsername:        registryUsername,
+		registryPassword:        registryPassword,
+		dockerConfig:            dockerConfig,
+		cosignImage:             image.ref,
+		cosignUser:              *cosignUser,
+		retry:                   retry,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*SignResult{}
+	for _, d := range digests {
+		signed, err := s.sign(ctx, d)
 		if err != nil {
 			return nil, err
 		}
 
-		stdouts = append(stdouts, stdout)
+		for _, r := range signed {
+			if r.Signer == signerCosign {
+				r.CosignImage = image.ref
+				r.CosignVersion = image.version
+			}
+		}
+
+		results = append(results, signed...)
+	}
+
+	return results, nil
+}
+
+// signOpts represents the options shared by each cosign sign invocation
+type signOpts struct {
+	keys                  []*signingKey
+	privateKeyAsFile      bool
+	annotations           []string
+	registryReferrersMode string
+	registryArgs          []string
+	dockerConfig          *dagger.File
+	cosignImage           string
+	cosignUser            string
+	retry                 *retryOpts
+}
+
+// signCtr returns the cosign Container with the given signing key configured
+// and the `cosign sign` command to sign the given digest with it
+func (f *Cosign) signCtr(
+	ctx context.Context,
+	// options shared by each cosign sign invocation
+	opts *signOpts,
+	// signing key
+	key *signingKey,
+	// Container image digest to sign
+	digest string,
+) (*dagger.Container, []string, error) {
+	ctr, keyRef, err := f.signingKeyCtr(ctx, opts, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ctr, signCmd(opts, keyRef, digest), nil
+}
+
+// signCmd returns the `cosign sign` command to sign the given digest with the
+// given key reference
+func signCmd(
+	// options shared by each cosign sign invocation
+	opts *signOpts,
+	// reference to pass to `--key`
+	keyRef string,
+	// Container image digest to sign
+	digest string,
+) []string {
+	cmd := append([]string{"cosign", "sign", digest, "--key", keyRef}, opts.registryArgs...)
+	for _, a := range opts.annotations {
+		cmd = append(cmd, "--annotations", a)
+	}
+
+	return append(cmd, referrersModeWriteArgs(opts.registryReferrersMode)...)
+}
+
+// signingKeyCtr returns the cosign Container configured for the registry
+// referrers mode with the given signing key, along with the reference to pass
+// to `--key`
+func (f *Cosign) signingKeyCtr(
+	ctx context.Context,
+	// options shared by each cosign sign invocation
+	opts *signOpts,
+	// signing key
+	key *signingKey,
+) (*dagger.Container, string, error) {
+	ctr, err := ctrWithReferrersMode(
+		f.cosignCtr(opts.cosignImage, opts.cosignUser, opts.dockerConfig),
+		opts.registryReferrersMode,
+	)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return stdouts, nil
+	return ctrWithSigningKey(
+		ctx,
+		ctr,
+		opts.cosignUser,
+		key,
+		opts.privateKeyAsFile,
+	)
 }
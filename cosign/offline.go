@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"fmt"
+)
+
+const (
+	// path the trusted root is mounted at
+	trustedRootPath = "/tmp/trusted_root.json"
+	// path the TUF root is mounted at (TUF_ROOT)
+	tufRootPath = "/tmp/sigstore-root"
+	// path the OCI layout is mounted at
+	layoutPath = "/tmp/layout"
+	// digest reported in the VerifyResult of an OCI layout
+	layoutDigest = "oci-layout"
+)
+
+// VerifyOffline will run cosign from the image, as defined by the cosignImage
+// parameter, to verify the given Container image digests, or the images in
+// the given OCI layout, entirely from local material without reaching Rekor,
+// Fulcio or TUF (i.e. in air-gapped environments)
+//
+// Signatures are verified against the Rekor bundles stored with them, using
+// the given trusted root or TUF root. Images may be read from a (mirror)
+// registry or, to avoid any registry, from an OCI layout as written by
+// `cosign save`.
+//
+// Either publicKey or the certificate identity & issuer (as VerifyKeyless)
+// are required
+//
+// See https://docs.sigstore.dev/cosign/verifying/verify/#verify-offline
+func (f *Cosign) VerifyOffline(
+	ctx context.Context,
+	// Sigstore trusted root JSON (e.g. trusted_root.json from the Sigstore
+	// TUF repository)
+	//+optional
+	trustedRoot *dagger.File,
+	// TUF root directory (TUF_ROOT), as populated by `cosign initialize`
+	// from the public or a mirrored TUF repository
+	//+optional
+	tufRoot *dagger.Directory,
+	// OCI layout, as written by `cosign save`, containing the images and
+	// their signatures to verify
+	//+optional
+	layout *dagger.Directory,
+	// Cosign public key, for signatures created with a key
+	//+optional
+	publicKey *dagger.File,
+	// Certificate identity (e.g. email or workflow URI) expected to have
+	// signed the digests
+	//+optional
+	certificateIdentity *string,
+	// Regular expression matching the certificate identity
+	//+optional
+	certificateIdentityRegexp *string,
+	// Certificate OIDC issuer expected to have issued the signing certificate
+	//+optional
+	certificateOidcIssuer *string,
+	// Regular expression matching the certificate OIDC issuer
+	//+optional
+	certificateOidcIssuerRegexp *string,
+	// Registry referrers mode to discover signatures with, one of:
+	// legacy (sha256-<digest>.sig tags), oci-1-1 (OCI 1.1 referrers API)
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Container image digests to verify
+	digests ...string,
+) ([]*VerifyResult, error) {
+	if trustedRoot == nil && tufRoot == nil {
+		return nil, fmt.Errorf("one of trustedRoot or tufRoot is required")
+	}
+	if layout == nil && len(digests) == 0 {
+		return nil, fmt.Errorf("one of layout or digests is required")
+	}
+
+	args := []string{"--offline=true", "--output", "json"}
+	ctr, err := ctrWithReferrersMode(
+		f.cosignCtr(cosignImageOrDefault(cosignImage), *cosignUser, dockerConfig),
+		*registryReferrersMode,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKey != nil {
+		const publicKeyPath = "/tmp/cosign.pub"
+		ctr = ctr.WithMountedFile(publicKeyPath, publicKey)
+		args = append(args, "--key", publicKeyPath)
+	} else {
+		certArgs, err := keylessArgs(
+			certificateIdentity,
+			certificateIdentityRegexp,
+			certificateOidcIssuer,
+			certificateOidcIssuerRegexp,
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, certArgs...)
+	}
+
+	if trustedRoot != nil {
+		ctr = ctr.WithMountedFile(trustedRootPath, trustedRoot)
+		args = append(args, "--trusted-root", trustedRootPath)
+	}
+	if tufRoot != nil {
+		ctr = ctr.
+			WithMountedDirectory(
+				tufRootPath,
+				tufRoot,
+				dagger.ContainerWithMountedDirectoryOpts{Owner: *cosignUser},
+			).
+			WithEnvVariable("TUF_ROOT", tufRootPath)
+	}
+
+	results := []*VerifyResult{}
+	if layout != nil {
+		cmd := append(
+			[]string{"cosign", "verify", "--local-image", layoutPath},
+			args...,
+		)
+		stdout, err := ctr.
+			WithMountedDirectory(layoutPath, layout).
+			WithExec(cmd).
+			Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := verifyResultFromOutput(layoutDigest, stdout)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, regArgs...)
+	args = append(args, referrersModeReadArgs(*registryReferrersMode)...)
+
+	for _, d := range digests {
+		cmd := append([]string{"cosign", "verify", d}, args...)
+		stdout, err := ctr.WithExec(cmd).Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := verifyResultFromOutput(d, stdout)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
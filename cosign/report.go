@@ -0,0 +1,153 @@
+package main
+
+import (
+	"dagger/cosign/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	reportFormatJson     = "json"
+	reportFormatMarkdown = "markdown"
+)
+
+// SignReport represents the audit record of signing events
+type SignReport struct {
+	// Time the report was generated (RFC 3339)
+	GeneratedAt string
+	// Signing events
+	Signatures []*SignResult
+}
+
+// Report returns an audit report of the given Sign results as a File, in JSON
+// for machines or Markdown for release notes, including the digests, key
+// fingerprints or identities, Rekor entries, annotations, cosign version and
+// timestamps of each signing event
+func (f *Cosign) Report(
+	// results as returned by Sign
+	results []*SignResult,
+	// Report format, one of: json, markdown
+	//+optional
+	//+default="json"
+	format *string,
+) (*dagger.File, error) {
+	report := &SignReport{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Signatures:  results,
+	}
+
+	return reportFile("signatures", *format, report, func() string {
+		return signReportMarkdown(report)
+	})
+}
+
+// signReportMarkdown renders the given SignReport as Markdown
+func signReportMarkdown(report *SignReport) string {
+	rows := [][]string{}
+	for _, r := range report.Signatures {
+		signer := r.Signer
+		if r.CosignVersion != "" {
+			signer = fmt.Sprintf("%s %s", r.Signer, r.CosignVersion)
+		}
+
+		key := fmt.Sprintf("`%s`", r.KeyFingerprint)
+		if r.Identity != "" {
+			key = fmt.Sprintf("%s (%s)", r.Identity, key)
+		}
+
+		rekor := ""
+		if r.RekorLogIndex != "" {
+			rekor = fmt.Sprintf(
+				"[%s](https://search.sigstore.dev/?logIndex=%s)",
+				r.RekorLogIndex,
+				r.RekorLogIndex,
+			)
+		}
+
+		annotations := []string{}
+		for _, a := range r.Annotations {
+			annotations = append(annotations, fmt.Sprintf("`%s`", a))
+		}
+
+		rows = append(rows, []string{
+			fmt.Sprintf("`%s`", r.Digest),
+			signer,
+			key,
+			rekor,
+			strings.Join(annotations, ", "),
+			r.SignedAt,
+		})
+	}
+
+	var b strings.Builder
+	b.WriteString("# Signatures\n\n")
+	fmt.Fprintf(&b, "Generated at %s\n\n", report.GeneratedAt)
+	b.WriteString(markdownTable(
+		[]string{"Digest", "Signer", "Key", "Rekor", "Annotations", "Signed At"},
+		rows,
+	))
+
+	return b.String()
+}
+
+// reportFile returns a File named after the given name and format containing
+// the given value rendered as JSON or, using the given markdown function, as
+// Markdown
+func reportFile(
+	// base name of the report file (without extension)
+	name string,
+	// report format, one of: json, markdown
+	format string,
+	// value to be rendered as JSON
+	v any,
+	// function rendering the value as Markdown
+	markdown func() string,
+) (*dagger.File, error) {
+	switch format {
+	case reportFormatJson:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error rendering %s report: %w", name, err)
+		}
+
+		return dag.File(fmt.Sprintf("%s.json", name), string(data)+"\n"), nil
+	case reportFormatMarkdown:
+		return dag.File(fmt.Sprintf("%s.md", name), markdown()), nil
+	}
+
+	return nil, fmt.Errorf(
+		"unsupported report format '%s', must be one of: %s, %s",
+		format,
+		reportFormatJson,
+		reportFormatMarkdown,
+	)
+}
+
+// markdownTable returns a Markdown table with the given header and rows,
+// escaping any pipe characters in the cells
+func markdownTable(header []string, rows [][]string) string {
+	escape := strings.NewReplacer("|", "\\|", "\n", " ")
+	line := func(cells []string) string {
+		escaped := make([]string, len(cells))
+		for i, c := range cells {
+			escaped[i] = escape.Replace(c)
+		}
+
+		return fmt.Sprintf("| %s |\n", strings.Join(escaped, " | "))
+	}
+
+	var b strings.Builder
+	b.WriteString(line(header))
+	separator := make([]string, len(header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	b.WriteString(line(separator))
+	for _, row := range rows {
+		b.WriteString(line(row))
+	}
+
+	return b.String()
+}
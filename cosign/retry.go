@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// cosign (registry & transparency log) output indicating a transient failure
+// worth retrying, any other failure is treated as permanent
+var retryableErrors = []string{
+	"429 too many requests",
+	"status code 429",
+	"toomanyrequests",
+	"too many requests",
+	"rate limit",
+	"500 internal server error",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"connection reset by peer",
+	"i/o timeout",
+	"tls handshake timeout",
+	"unexpected eof",
+	"context deadline exceeded",
+}
+
+// Rekor (go-swagger client) error status codes indicating a transient failure,
+// e.g. [POST /api/v1/log/entries][503] createLogEntry default
+var retryableStatusRegexp = regexp.MustCompile(`\]\[(?:429|5\d\d)\]`)
+
+// retryOpts represents how failed invocations are retried
+type retryOpts struct {
+	// number of retries after the initial attempt
+	retries int
+	// backoff before the first retry, doubled for each subsequent retry
+	backoff time.Duration
+}
+
+// newRetryOpts returns the retry options for the given number of retries and
+// initial backoff duration (e.g. 2s)
+func newRetryOpts(retries int, backoff string) (*retryOpts, error) {
+	if retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative: %d", retries)
+	}
+
+	d, err := time.ParseDuration(backoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry backoff '%s': %w", backoff, err)
+	}
+
+	return &retryOpts{retries: retries, backoff: d}, nil
+}
+
+// stdoutWithRetry executes the given command on the given Container, as
+// execWithRetry, returning its stdout
+func stdoutWithRetry(
+	ctx context.Context,
+	// retry options, if nil the command is not retried
+	opts *retryOpts,
+	// Container to execute the command on
+	ctr *dagger.Container,
+	// command to be executed
+	cmd []string,
+) (string, error) {
+	ctr, err := execWithRetry(ctx, opts, ctr, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	return ctr.Stdout(ctx)
+}
+
+// execWithRetry executes the given command on the given Container returning
+// the evaluated Container, retrying with exponential backoff while the
+// failure is retryable. The attempt number is set on the Container prior to
+// execution so each retry is evaluated rather than served from cache.
+func execWithRetry(
+	ctx context.Context,
+	// retry options, if nil the command is not retried
+	opts *retryOpts,
+	// Container to execute the command on
+	ctr *dagger.Container,
+	// command to be executed
+	cmd []string,
+) (*dagger.Container, error) {
+	if opts == nil {
+		return ctr.WithExec(cmd).Sync(ctx)
+	}
+
+	backoff := opts.backoff
+	for attempt := 0; ; attempt++ {
+		executed, err := ctr.
+			WithEnvVariable("COSIGN_ATTEMPT", fmt.Sprintf("%d", attempt)).
+			WithExec(cmd).
+			Sync(ctx)
+		if err == nil || attempt >= opts.retries || !retryable(err) {
+			return executed, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable returns true if the given error is an exec error with output
+// matching a transient failure
+func retryable(err error) bool {
+	var execErr *dagger.ExecError
+	if !errors.As(err, &execErr) {
+		return false
+	}
+
+	output := strings.ToLower(execErr.Stdout + execErr.Stderr)
+	for _, e := range retryableErrors {
+		if strings.Contains(output, e) {
+			return true
+		}
+	}
+
+	return retryableStatusRegexp.MatchString(output)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"dagger/cosign/internal/dagger"
+	"errors"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   bool
+	}{
+		{
+			name:   "registry rate limit",
+			stderr: "Error: signing [docker.io/org/image@sha256:aaaa]: GET https://index.docker.io/v2/org/image/manifests/sha256-aaaa.sig: TOOMANYREQUESTS: You have reached your pull rate limit.",
+			want:   true,
+		},
+		{
+			name:   "registry status code 429",
+			stderr: "Error: signing [ghcr.io/org/image@sha256:aaaa]: PUT https://ghcr.io/v2/org/image/manifests/sha256-aaaa.sig: unexpected status code 429 Too Many Requests",
+			want:   true,
+		},
+		{
+			name:   "registry bad gateway",
+			stderr: "Error: signing [ghcr.io/org/image@sha256:aaaa]: GET https://ghcr.io/v2/org/image/manifests/sha256-aaaa.sig: unexpected status code 502 Bad Gateway",
+			want:   true,
+		},
+		{
+			name:   "rekor service unavailable",
+			stderr: "Error: signing [ghcr.io/org/image@sha256:aaaa]: creating transparency log entry: [POST /api/v1/log/entries][503] createLogEntry default  &{Code:503 Message:service unavailable}",
+			want:   true,
+		},
+		{
+			name:   "rekor timeout",
+			stderr: `Error: signing [ghcr.io/org/image@sha256:aaaa]: Post "https://rekor.sigstore.dev/api/v1/log/entries": dial tcp 1.2.3.4:443: i/o timeout`,
+			want:   true,
+		},
+		{
+			name:   "rekor bad request",
+			stderr: "Error: signing [ghcr.io/org/image@sha256:aaaa]: creating transparency log entry: [POST /api/v1/log/entries][400] createLogEntryBadRequest  &{Code:400 Message:error processing entry}",
+			want:   false,
+		},
+		{
+			name:   "registry unauthorized",
+			stderr: "Error: signing [ghcr.io/org/image@sha256:aaaa]: PUT https://ghcr.io/v2/org/image/manifests/sha256-aaaa.sig: UNAUTHORIZED: authentication required",
+			want:   false,
+		},
+		{
+			name:   "digest containing 429",
+			stderr: "Error: signing [ghcr.io/org/image@sha256:4290aaaa]: GET https://ghcr.io/v2/org/image/manifests/sha256:4290aaaa: MANIFEST_UNKNOWN: manifest unknown",
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &dagger.ExecError{Stderr: tt.stderr}
+			if got := retryable(err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if retryable(errors.New("[POST /api/v1/log/entries][503]")) {
+		t.Error("retryable() = true for a non exec error, want false")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"fmt"
+)
+
+const (
+	sbomFormatSpdx      = "spdx"
+	sbomFormatCyclonedx = "cyclonedx"
+)
+
+// AttestSbom will generate an SBOM of the given Container image digest with
+// syft, in the given format (spdx or cyclonedx), and attest it with the
+// given private key(s), returning the SBOM
+//
+// See https://github.com/anchore/syft
+func (f *Cosign) AttestSbom(
+	ctx context.Context,
+	// Container image digest to generate the SBOM of and attest
+	digest string,
+	// Cosign private key
+	privateKey *dagger.Secret,
+	// Cosign password, if the private key is encrypted with one
+	//+optional
+	password *dagger.Secret,
+	// additional Cosign private keys to attest with (e.g. during key rotation)
+	//+optional
+	additionalPrivateKeys []*dagger.Secret,
+	// passwords for the additional Cosign private keys, matched by index
+	//+optional
+	additionalPasswords []*dagger.Secret,
+	// Mount the private keys as secret files rather than environment variables
+	//+optional
+	//+default=false
+	privateKeyAsFile bool,
+	// SBOM format, one of: spdx, cyclonedx
+	//+optional
+	//+default="spdx"
+	format *string,
+	// Syft container image
+	//+optional
+	//+default="anchore/syft:latest"
+	syftImage *string,
+	// Registry referrers mode to store attestations with, one of:
+	// legacy (sha256-<digest>.att tags), oci-1-1 (OCI 1.1 referrers API)
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+) (*dagger.File, error) {
+	syftFormat, predicateType := "", ""
+	switch *format {
+	case sbomFormatSpdx:
+		syftFormat, predicateType = "spdx-json", "spdxjson"
+	case sbomFormatCyclonedx:
+		syftFormat, predicateType = "cyclonedx-json", "cyclonedx"
+	default:
+		return nil, fmt.Errorf(
+			"unsupported SBOM format '%s', must be one of: %s, %s",
+			*format,
+			sbomFormatSpdx,
+			sbomFormatCyclonedx,
+		)
+	}
+
+	keys, err := signingKeys(
+		privateKey,
+		password,
+		additionalPrivateKeys,
+		additionalPasswords,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := f.pinCosignImage(
+		ctx,
+		cosignImageOrDefault(cosignImage),
+		*cosignUser,
+		false,
+		"",
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	syft := ctrWithDockerConfigEnv(dag.Container().From(*syftImage), dockerConfig)
+	syft = anchoreCtrWithRegistryAuth(
+		syft,
+		"SYFT",
+		registryUsername,
+		registryPassword,
+		digest,
+	)
+	sbom, err := syft.
+		WithExec([]string{
+			"/syft", fmt.Sprintf("registry:%s", digest), "--output", syftFormat,
+		}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error generating SBOM for '%s': %w", digest, err)
+	}
+
+	sbomFile := dag.File(fmt.Sprintf("sbom.%s.json", *format), sbom)
+	_, err = f.attest(
+		ctx,
+		&signOpts{
+			keys:                  keys,
+			privateKeyAsFile:      privateKeyAsFile,
+			registryReferrersMode: *registryReferrersMode,
+			registryArgs:          regArgs,
+			dockerConfig:          dockerConfig,
+			cosignImage:           pinned.ref,
+			cosignUser:            *cosignUser,
+		},
+		sbomFile,
+		predicateType,
+		digest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return sbomFile, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"dagger/cosign/internal/dagger"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// cosign sign output reporting the Rekor transparency log index
+var rekorLogIndexRegexp = regexp.MustCompile(`tlog entry created with index: (\d+)`)
+
+const (
+	signerCosign   = "cosign"
+	signerNotation = "notation"
+)
+
+// signer represents a backend signing Container image digests
+type signer interface {
+	// sign signs the given digest returning the result of each signing
+	sign(ctx context.Context, digest string) ([]*SignResult, error)
+}
+
+// signerOpts represents the options used to construct a signer
+type signerOpts struct {
+	signer                  string
+	privateKey              *dagger.Secret
+	password                *dagger.Secret
+	additionalPrivateKeys   []*dagger.Secret
+	additionalPasswords     []*dagger.Secret
+	privateKeyAsFile        bool
+	annotations             []string
+	notationCertificate     *dagger.File
+	notationSignatureFormat string
+	notationImage           *string
+	registryReferrersMode   string
+	registryUsername        *string
+	registryPassword        *dagger.Secret
+	dockerConfig            *dagger.File
+	cosignImage             string
+	cosignUser              string
+	retry                   *retryOpts
+}
+
+// newSigner returns the signer backend for the given options
+func (f *Cosign) newSigner(ctx context.Context, opts *signerOpts) (signer, error) {
+	for _, a := range opts.annotations {
+		if !strings.Contains(a, "=") {
+			return nil, fmt.Errorf("annotation '%s' must be in the form key=value", a)
+		}
+	}
+
+	switch opts.signer {
+	case signerCosign:
+		keys, err := signingKeys(
+			opts.privateKey,
+			opts.password,
+			opts.additionalPrivateKeys,
+			opts.additionalPasswords,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		regArgs, err := registryArgs(ctx, opts.registryUsername, opts.registryPassword)
+		if err != nil {
+			return nil, err
+		}
+
+		return &cosignSigner{
+			cosign:       f,
+			fingerprints: map[int]string{},
+			opts: &signOpts{
+				keys:                  keys,
+				privateKeyAsFile:      opts.privateKeyAsFile,
+				annotations:           opts.annotations,
+				registryReferrersMode: opts.registryReferrersMode,
+				registryArgs:          regArgs,
+				dockerConfig:          opts.dockerConfig,
+				cosignImage:           opts.cosignImage,
+				cosignUser:            opts.cosignUser,
+				retry:                 opts.retry,
+			},
+		}, nil
+	case signerNotation:
+		if opts.notationCertificate == nil {
+			return nil, fmt.Errorf("notationCertificate is required with the notation signer")
+		}
+		if opts.notationImage == nil {
+			return nil, fmt.Errorf("notationImage is required with the notation signer")
+		}
+		if opts.password != nil {
+			return nil, fmt.Errorf("password is not supported with the notation signer, the private key must be unencrypted")
+		}
+		if opts.privateKeyAsFile {
+			return nil, fmt.Errorf("privateKeyAsFile is not supported with the notation signer, the private key is always mounted as a file")
+		}
+		if len(opts.additionalPrivateKeys) > 0 {
+			return nil, fmt.Errorf("additional private keys are not supported with the notation signer")
+		}
+		if opts.registryReferrersMode != referrersModeLegacy {
+			return nil, fmt.Errorf("registry referrers mode is not supported with the notation signer")
+		}
+
+		return &notationSigner{
+			privateKey:       opts.privateKey,
+			certificate:      opts.notationCertificate,
+			signatureFormat:  opts.notationSignatureFormat,
+			annotations:      opts.annotations,
+			image:            *opts.notationImage,
+			registryUsername: opts.registryUsername,
+			registryPassword: opts.registryPassword,
+			dockerConfig:     opts.dockerConfig,
+			retry:            opts.retry,
+		}, nil
+	}
+
+	return nil, fmt.Errorf(
+		"unsupported signer '%s', must be one of: %s, %s",
+		opts.signer,
+		signerCosign,
+		signerNotation,
+	)
+}
+
+// cosignSigner signs Container image digests with cosign, using each of the
+// signing keys
+type cosignSigner struct {
+	cosign *Cosign
+	opts   *signOpts
+	// public key fingerprints by key index
+	fingerprints map[int]string
+}
+
+func (s *cosignSigner) sign(ctx context.Context, digest string) ([]*SignResult, error) {
+	results := []*SignResult{}
+	for i, key := range s.opts.keys {
+		ctr, keyRef, err := s.cosign.signingKeyCtr(ctx, s.opts, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := s.fingerprints[i]; !ok {
+			publicKey, err := ctr.
+				WithExec([]string{"cosign", "public-key", "--key", keyRef}).
+				Stdout(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s.fingerprints[i], err = publicKeyFingerprint(publicKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		signed, err := execWithRetry(
+			ctx,
+			s.opts.retry,
+			ctr,
+			signCmd(s.opts, keyRef, digest),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := signResult(ctx, signed, digest, s.opts.annotations)
+		if err != nil {
+			return nil, err
+		}
+		result.Signer = signerCosign
+		result.Key = i
+		result.KeyFingerprint = s.fingerprints[i]
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// notationSigner signs Container image digests with Notation (Notary v2)
+// using a local key and certificate
+//
+// See https://notaryproject.dev/docs/user-guides/how-to/notation-config-file/
+type notationSigner struct {
+	privateKey       *dagger.Secret
+	certificate      *dagger.File
+	signatureFormat  string
+	annotations      []string
+	image            string
+	registryUsername *string
+	registryPassword *dagger.Secret
+	dockerConfig     *dagger.File
+	retry            *retryOpts
+}
+
+// notation signing keys configuration (signingkeys.json)
+type notationSigningKeys struct {
+	Default string               `json:"default"`
+	Keys    []notationSigningKey `json:"keys"`
+}
+
+type notationSigningKey struct {
+	Name     string `json:"name"`
+	KeyPath  string `json:"keyPath"`
+	CertPath string `json:"certPath"`
+}
+
+func (s *notationSigner) sign(ctx context.Context, digest string) ([]*SignResult, error) {
+	const (
+		keyName   = "dagger"
+		configDir = "/tmp/notation"
+		keyPath   = "/run/secrets/notation.key"
+		certPath  = "/run/secrets/notation.crt"
+	)
+
+	signingKeys, err := json.Marshal(notationSigningKeys{
+		Default: keyName,
+		Keys: []notationSigningKey{
+			{Name: keyName, KeyPath: keyPath, CertPath: certPath},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error rendering notation signing keys: %w", err)
+	}
+
+	ctr := dag.Container().From(s.image)
+
+	// the key & configuration are owned by the user of the image, which may not
+	// be root
+	user, err := ctr.User(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr = ctrWithDockerConfigEnv(ctr, s.dockerConfig).
+		WithEnvVariable("XDG_CONFIG_HOME", "/tmp").
+		WithNewFile(
+			fmt.Sprintf("%s/signingkeys.json", configDir),
+			string(signingKeys),
+			dagger.ContainerWithNewFileOpts{Owner: user},
+		).
+		WithMountedSecret(
+			keyPath,
+			s.privateKey,
+			dagger.ContainerWithMountedSecretOpts{Owner: user},
+		).
+		WithMountedFile(certPath, s.certificate, dagger.ContainerWithMountedFileOpts{Owner: user})
+
+	if s.registryUsername != nil && s.registryPassword != nil {
+		ctr = ctr.
+			WithEnvVariable("NOTATION_USERNAME", *s.registryUsername).
+			WithSecretVariable("NOTATION_PASSWORD", s.registryPassword)
+	}
+
+	cmd := []string{
+		"notation", "sign", digest,
+		"--key", keyName,
+		"--signature-format", s.signatureFormat,
+	}
+	for _, a := range s.annotations {
+		cmd = append(cmd, "--user-metadata", a)
+	}
+
+	signed, err := execWithRetry(ctx, s.retry, ctr, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := signResult(ctx, signed, digest, s.annotations)
+	if err != nil {
+		return nil, err
+	}
+	result.Signer = signerNotation
+
+	certificate, err := s.certificate.Contents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return nil, fmt.Errorf("notation certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing notation certificate: %w", err)
+	}
+	result.Identity = cert.Subject.String()
+	result.KeyFingerprint = fingerprint(cert.RawSubjectPublicKeyInfo)
+
+	return []*SignResult{result}, nil
+}
+
+// signResult returns the SignResult of the given signed (executed) Container
+// for the given digest
+func signResult(
+	ctx context.Context,
+	// executed signer Container
+	signed *dagger.Container,
+	// Container image digest signed
+	digest string,
+	// annotations added to the signature
+	annotations []string,
+) (*SignResult, error) {
+	stdout, err := signed.Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := signed.Stderr(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SignResult{
+		Digest:      digest,
+		Annotations: annotations,
+		SignedAt:    time.Now().UTC().Format(time.RFC3339),
+		Output:      stdout,
+	}
+	if m := rekorLogIndexRegexp.FindStringSubmatch(stderr); m != nil {
+		result.RekorLogIndex = m[1]
+	}
+
+	return result, nil
+}
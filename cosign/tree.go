@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+const (
+	artifactKindSignature   = "signature"
+	artifactKindAttestation = "attestation"
+	artifactKindSbom        = "sbom"
+	artifactKindOther       = "other"
+)
+
+// Artifact represents a supply chain artifact attached to a container image
+// digest (i.e. signature, attestation, SBOM)
+type Artifact struct {
+	// Kind of artifact, one of: signature, attestation, sbom, other
+	Kind string
+	// Reference the artifact is stored at (tag or OCI referrer)
+	Reference string
+	// Digest of the artifact
+	Digest string
+}
+
+// Inventory represents the supply chain artifacts attached to a container
+// image digest
+type Inventory struct {
+	// Container image digest inventoried
+	Digest string
+	// Signatures attached to the digest
+	Signatures []*Artifact
+	// Attestations attached to the digest
+	Attestations []*Artifact
+	// SBOMs attached to the digest
+	Sboms []*Artifact
+	// Other artifacts attached to the digest (i.e. unknown OCI referrers)
+	Other []*Artifact
+}
+
+// SignatureStatus represents whether a container image digest has any
+// signatures attached
+type SignatureStatus struct {
+	// Container image digest checked
+	Digest string
+	// Whether any signatures are attached to the digest
+	Signed bool
+	// Signatures attached to the digest
+	Signatures []*Artifact
+}
+
+// Tree will run cosign from the image, as defined by the cosignImage
+// parameter, to list the signatures, attestations and SBOMs attached to the
+// given Container image digests
+//
+// See https://github.com/sigstore/cosign/blob/main/doc/cosign_tree.md
+func (f *Cosign) Tree(
+	ctx context.Context,
+	// Registry referrers mode artifacts are stored with, one of:
+	// legacy, oci-1-1
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Container image digests to inventory
+	digests ...string,
+) ([]*Inventory, error) {
+	inventories := []*Inventory{}
+	for _, d := range digests {
+		artifacts, err := f.tree(
+			ctx,
+			*registryReferrersMode,
+			registryUsername,
+			registryPassword,
+			dockerConfig,
+			cosignImageOrDefault(cosignImage),
+			*cosignUser,
+			d,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		inventories = append(inventories, inventoryFromArtifacts(d, artifacts))
+	}
+
+	return inventories, nil
+}
+
+// TreeReport will run Tree for the given Container image digests returning
+// the inventory as a File in the given format
+func (f *Cosign) TreeReport(
+	ctx context.Context,
+	// Report format, one of: json, markdown
+	//+optional
+	//+default="json"
+	format *string,
+	// Registry referrers mode artifacts are stored with, one of:
+	// legacy, oci-1-1
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Container image digests to inventory
+	digests ...string,
+) (*dagger.File, error) {
+	inventories, err := f.Tree(
+		ctx,
+		registryReferrersMode,
+		registryUsername,
+		registryPassword,
+		dockerConfig,
+		cosignImage,
+		cosignUser,
+		digests...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return reportFile("tree", *format, inventories, func() string {
+		return inventoriesMarkdown(inventories)
+	})
+}
+
+// Signed will run cosign from the image, as defined by the cosignImage
+// parameter, to check whether the given Container image digests have any
+// signatures attached, stored as either tags (legacy) or OCI 1.1 referrers
+//
+// Note: signatures are not verified, see VerifyKeyless & VerifyKeySet
+func (f *Cosign) Signed(
+	ctx context.Context,
+	// Registry referrers mode signatures are stored with, one of:
+	// legacy, oci-1-1
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Container image digests to check
+	digests ...string,
+) ([]*SignatureStatus, error) {
+	statuses := []*SignatureStatus{}
+	for _, d := range digests {
+		artifacts, err := f.tree(
+			ctx,
+			*registryReferrersMode,
+			registryUsername,
+			registryPassword,
+			dockerConfig,
+			cosignImageOrDefault(cosignImage),
+			*cosignUser,
+			d,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		signatures := inventoryFromArtifacts(d, artifacts).Signatures
+		statuses = append(statuses, &SignatureStatus{
+			Digest:     d,
+			Signed:     len(signatures) > 0,
+			Signatures: signatures,
+		})
+	}
+
+	return statuses, nil
+}
+
+// inventoryFromArtifacts returns the Inventory for the given digest with the
+// given artifacts grouped by kind
+func inventoryFromArtifacts(digest string, artifacts []*Artifact) *Inventory {
+	inventory := &Inventory{
+		Digest:       digest,
+		Signatures:   []*Artifact{},
+		Attestations: []*Artifact{},
+		Sboms:        []*Artifact{},
+		Other:        []*Artifact{},
+	}
+	for _, a := range artifacts {
+		switch a.Kind {
+		case artifactKindSignature:
+			inventory.Signatures = append(inventory.Signatures, a)
+		case artifactKindAttestation:
+			inventory.Attestations = append(inventory.Attestations, a)
+		case artifactKindSbom:
+			inventory.Sboms = append(inventory.Sboms, a)
+		default:
+			inventory.Other = append(inventory.Other, a)
+		}
+	}
+
+	return inventory
+}
+
+// inventoriesMarkdown renders the given inventories as Markdown with a
+// section per digest
+func inventoriesMarkdown(inventories []*Inventory) string {
+	var b strings.Builder
+	b.WriteString("# Supply Chain Artifacts\n")
+	for _, inventory := range inventories {
+		fmt.Fprintf(&b, "\n## `%s`\n\n", inventory.Digest)
+		fmt.Fprintf(&b, "- Signatures: %d\n", len(inventory.Signatures))
+		fmt.Fprintf(&b, "- Attestations: %d\n", len(inventory.Attestations))
+		fmt.Fprintf(&b, "- SBOMs: %d\n", len(inventory.Sboms))
+		fmt.Fprintf(&b, "- Other: %d\n", len(inventory.Other))
+
+		artifacts := append([]*Artifact{}, inventory.Signatures...)
+		artifacts = append(artifacts, inventory.Attestations...)
+		artifacts = append(artifacts, inventory.Sboms...)
+		artifacts = append(artifacts, inventory.Other...)
+		if len(artifacts) == 0 {
+			continue
+		}
+
+		rows := [][]string{}
+		for _, a := range artifacts {
+			rows = append(rows, []string{a.Kind, a.Reference, a.Digest})
+		}
+		b.WriteString("\n")
+		b.WriteString(markdownTable([]string{"Kind", "Reference", "Digest"}, rows))
+	}
+
+	return b.String()
+}
+
+// tree runs `cosign tree` against the given digest returning the supply
+// chain artifacts attached to it
+func (f *Cosign) tree(
+	ctx context.Context,
+	// registry referrers mode
+	mode string,
+	// registry username
+	registryUsername *string,
+	// registry password
+	registryPassword *dagger.Secret,
+	// Docker config
+	dockerConfig *dagger.File,
+	// Cosign container image
+	cosignImage string,
+	// Cosign container image user
+	cosignUser string,
+	// Container image digest
+	digest string,
+) ([]*Artifact, error) {
+	ctr, err := ctrWithReferrersMode(
+		f.cosignCtr(cosignImage, cosignUser, dockerConfig),
+		mode,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := append([]string{"cosign", "tree", digest}, referrersModeReadArgs(mode)...)
+	cmd = append(cmd, regArgs...)
+	stdout, err := ctr.WithExec(cmd).Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTree(stdout), nil
+}
+
+// parseTree parses the output of `cosign tree`, e.g.
+//
+//	📦 Supply Chain Security Related artifacts for an image: <image>
+//	└── 🔐 Signatures for an image tag: <registry>/<repo>:sha256-<digest>.sig
+//	   ├── 🍒 sha256:<digest>
+//	   └── 🍒 sha256:<digest>
+//
+// or, using the OCI 1.1 referrers API
+//
+//	📦 Supply Chain Security Related artifacts for an image: <image>
+//	└── 🔗 <artifact type> artifacts via OCI referrer: <image>
+//	   └── 🍒 sha256:<digest>
+//
+// Groups start at the beginning of the line, their artifacts (layers) are
+// indented, each with either connector
+func parseTree(output string) []*Artifact {
+	artifacts := []*Artifact{}
+	kind, reference := "", ""
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimLeft(line, " │")
+		item, found := treeItem(trimmed)
+		if !found {
+			continue
+		}
+
+		if trimmed == line {
+			description, ref, _ := strings.Cut(item, ": ")
+			kind, reference = artifactKind(description), strings.TrimSpace(ref)
+
+			continue
+		}
+
+		fields := strings.Fields(item)
+		if kind == "" || len(fields) == 0 {
+			continue
+		}
+		artifacts = append(artifacts, &Artifact{
+			Kind:      kind,
+			Reference: reference,
+			Digest:    fields[len(fields)-1],
+		})
+	}
+
+	return artifacts
+}
+
+// treeItem returns the given `cosign tree` line without its connector, and
+// whether it had one
+func treeItem(line string) (string, bool) {
+	for _, connector := range []string{"├── ", "└── "} {
+		if item, found := strings.CutPrefix(line, connector); found {
+			return item, true
+		}
+	}
+
+	return "", false
+}
+
+// artifactKind returns the kind of artifact from the given `cosign tree`
+// group description
+func artifactKind(description string) string {
+	d := strings.ToLower(description)
+	switch {
+	case strings.Contains(d, "signature"), strings.Contains(d, ".sig."):
+		return artifactKindSignature
+	case strings.Contains(d, "attestation"), strings.Contains(d, ".att."):
+		return artifactKindAttestation
+	case strings.Contains(d, "sbom"):
+		return artifactKindSbom
+	}
+
+	return artifactKindOther
+}
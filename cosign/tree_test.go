@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseTree(t *testing.T) {
+	const output = `📦 Supply Chain Security Related artifacts for an image: ghcr.io/org/image@sha256:aaaa
+└── 💾 Attestations for an image tag: ghcr.io/org/image:sha256-aaaa.att
+   └── 🍒 sha256:1111
+└── 🔐 Signatures for an image tag: ghcr.io/org/image:sha256-aaaa.sig
+   ├── 🍒 sha256:2222
+   ├── 🍒 sha256:3333
+   └── 🍒 sha256:4444
+`
+
+	want := []Artifact{
+		{Kind: artifactKindAttestation, Reference: "ghcr.io/org/image:sha256-aaaa.att", Digest: "sha256:1111"},
+		{Kind: artifactKindSignature, Reference: "ghcr.io/org/image:sha256-aaaa.sig", Digest: "sha256:2222"},
+		{Kind: artifactKindSignature, Reference: "ghcr.io/org/image:sha256-aaaa.sig", Digest: "sha256:3333"},
+		{Kind: artifactKindSignature, Reference: "ghcr.io/org/image:sha256-aaaa.sig", Digest: "sha256:4444"},
+	}
+
+	got := parseTree(output)
+	if len(got) != len(want) {
+		t.Fatalf("parseTree() returned %d artifacts, want %d", len(got), len(want))
+	}
+
+	for i, a := range got {
+		if *a != want[i] {
+			t.Errorf("parseTree()[%d] = %+v, want %+v", i, *a, want[i])
+		}
+	}
+}
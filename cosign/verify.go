@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// VerifyResult represents the result of verifying a container image digest
+type VerifyResult struct {
+	// Container image digest verified
+	Digest string
+	// Certificate identity (subject) of the matched signature
+	Identity string
+	// Certificate OIDC issuer of the matched signature
+	Issuer string
+	// Raw cosign verify output (JSON)
+	Output string
+}
+
+// verifyPayload represents a single element of the JSON output of
+// `cosign verify`
+type verifyPayload struct {
+	Optional map[string]any `json:"optional"`
+}
+
+// VerifyKeyless will run cosign from the image, as defined by the cosignImage
+// parameter, to verify the given Container image digests were signed
+// keylessly by the expected certificate identity and issuer
+//
+// Either certificateIdentity or certificateIdentityRegexp and either
+// certificateOidcIssuer or certificateOidcIssuerRegexp are required
+//
+// See https://docs.sigstore.dev/cosign/verifying/verify/
+func (f *Cosign) VerifyKeyless(
+	ctx context.Context,
+	// Certificate identity (e.g. email or workflow URI) expected to have
+	// signed the digests
+	//+optional
+	certificateIdentity *string,
+	// Regular expression matching the certificate identity
+	//+optional
+	certificateIdentityRegexp *string,
+	// Certificate OIDC issuer expected to have issued the signing certificate
+	//+optional
+	certificateOidcIssuer *string,
+	// Regular expression matching the certificate OIDC issuer
+	//+optional
+	certificateOidcIssuerRegexp *string,
+	// GitHub workflow trigger claim (e.g. push)
+	//+optional
+	certificateGithubWorkflowTrigger *string,
+	// GitHub workflow SHA claim
+	//+optional
+	certificateGithubWorkflowSha *string,
+	// GitHub workflow name claim
+	//+optional
+	certificateGithubWorkflowName *string,
+	// GitHub workflow repository claim (e.g. owner/repo)
+	//+optional
+	certificateGithubWorkflowRepository *string,
+	// GitHub workflow ref claim (e.g. refs/heads/main)
+	//+optional
+	certificateGithubWorkflowRef *string,
+	// Registry referrers mode to discover signatures with, one of:
+	// legacy (sha256-<digest>.sig tags), oci-1-1 (OCI 1.1 referrers API)
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Container image digests to verify
+	digests ...string,
+) ([]*VerifyResult, error) {
+	args, err := keylessArgs(
+		certificateIdentity,
+		certificateIdentityRegexp,
+		certificateOidcIssuer,
+		certificateOidcIssuerRegexp,
+		map[string]*string{
+			"--certificate-github-workflow-trigger":    certificateGithubWorkflowTrigger,
+			"--certificate-github-workflow-sha":        certificateGithubWorkflowSha,
+			"--certificate-github-workflow-name":       certificateGithubWorkflowName,
+			"--certificate-github-workflow-repository": certificateGithubWorkflowRepository,
+			"--certificate-github-workflow-ref":        certificateGithubWorkflowRef,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, regArgs...)
+
+	args = append(args, referrersModeReadArgs(*registryReferrersMode)...)
+
+	results := []*VerifyResult{}
+	for _, d := range digests {
+		ctr, err := ctrWithReferrersMode(
+			f.cosignCtr(cosignImageOrDefault(cosignImage), *cosignUser, dockerConfig),
+			*registryReferrersMode,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd := append([]string{"cosign", "verify", d, "--output", "json"}, args...)
+		stdout, err := ctr.WithExec(cmd).Stdout(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := verifyResultFromOutput(d, stdout)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// keylessArgs returns the cosign verify arguments matching the certificate
+// identity & issuer along with any additional certificate claims (flag to
+// value), requiring one of the identity and one of the issuer arguments
+func keylessArgs(
+	// Certificate identity
+	certificateIdentity *string,
+	// Regular expression matching the certificate identity
+	certificateIdentityRegexp *string,
+	// Certificate OIDC issuer
+	certificateOidcIssuer *string,
+	// Regular expression matching the certificate OIDC issuer
+	certificateOidcIssuerRegexp *string,
+	// additional certificate claims, flag to value (nil values are skipped)
+	claims map[string]*string,
+) ([]string, error) {
+	if certificateIdentity == nil && certificateIdentityRegexp == nil {
+		return nil, fmt.Errorf(
+			"one of certificateIdentity or certificateIdentityRegexp is required",
+		)
+	}
+	if certificateOidcIssuer == nil && certificateOidcIssuerRegexp == nil {
+		return nil, fmt.Errorf(
+			"one of certificateOidcIssuer or certificateOidcIssuerRegexp is required",
+		)
+	}
+
+	flags := map[string]*string{
+		"--certificate-identity":           certificateIdentity,
+		"--certificate-identity-regexp":    certificateIdentityRegexp,
+		"--certificate-oidc-issuer":        certificateOidcIssuer,
+		"--certificate-oidc-issuer-regexp": certificateOidcIssuerRegexp,
+	}
+	maps.Copy(flags, claims)
+
+	args := []string{}
+	for _, flag := range slices.Sorted(maps.Keys(flags)) {
+		if flags[flag] != nil {
+			args = append(args, flag, *flags[flag])
+		}
+	}
+
+	return args, nil
+}
+
+// verifyResultFromOutput parses the JSON output of `cosign verify` for the
+// given digest, returning the identity and issuer of the first verified
+// signature
+func verifyResultFromOutput(digest string, output string) (*VerifyResult, error) {
+	payloads := []verifyPayload{}
+	if err := json.Unmarshal([]byte(output), &payloads); err != nil {
+		return nil, fmt.Errorf("error parsing cosign verify output for '%s': %w", digest, err)
+	}
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("no verified signatures found for '%s'", digest)
+	}
+
+	result := &VerifyResult{Digest: digest, Output: output}
+	if subject, ok := payloads[0].Optional["Subject"].(string); ok {
+		result.Identity = subject
+	}
+	if issuer, ok := payloads[0].Optional["Issuer"].(string); ok {
+		result.Issuer = issuer
+	}
+
+	return result, nil
+}
+
+// unsignedErrors are the cosign verify error messages of a digest with no
+// signature matching the public key
+var unsignedErrors = []string{
+	"no matching signatures",
+	"no signatures found",
+}
+
+// KeySetResult represents the result of verifying a container image digest
+// against a set of public keys
+type KeySetResult struct {
+	// Container image digest verified
+	Digest string
+	// Whether the digest satisfied the key set policy
+	Verified bool
+	// SHA-256 fingerprints of the public keys the digest is signed by
+	Keys []string
+}
+
+// VerifyKeySet will run cosign from the image, as defined by the cosignImage
+// parameter, to check the given Container image digests are signed by any or
+// all of the given public keys, as defined by the policy parameter
+//
+// Digests not satisfying the policy are returned with Verified false, an error
+// is only returned if verification could not be performed (e.g. registry
+// errors)
+func (f *Cosign) VerifyKeySet(
+	ctx context.Context,
+	// Cosign public keys
+	publicKeys []*dagger.File,
+	// Key set policy, one of: any, all
+	//+optional
+	//+default="any"
+	policy *string,
+	// Registry referrers mode to discover signatures with, one of:
+	// legacy (sha256-<digest>.sig tags), oci-1-1 (OCI 1.1 referrers API)
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+	// Container image digests to verify
+	digests ...string,
+) ([]*KeySetResult, error) {
+	if len(publicKeys) == 0 {
+		return nil, fmt.Errorf("at least one public key is required")
+	}
+	if *policy != "any" && *policy != "all" {
+		return nil, fmt.Errorf("unsupported key set policy '%s', must be one of: any, all", *policy)
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+	regArgs = append(regArgs, referrersModeReadArgs(*registryReferrersMode)...)
+
+	fingerprints := []string{}
+	for i, k := range publicKeys {
+		contents, err := k.Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint, err := publicKeyFingerprint(contents)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %d: %w", i, err)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
+	results := []*KeySetResult{}
+	for _, d := range digests {
+		result := &KeySetResult{Digest: d, Keys: []string{}}
+		for i, k := range publicKeys {
+			ctr, err := ctrWithReferrersMode(
+				f.cosignCtr(cosignImageOrDefault(cosignImage), *cosignUser, dockerConfig),
+				*registryReferrersMode,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			verified, err := f.verifyWithKey(
+				ctx,
+				ctr,
+				k,
+				regArgs,
+				d,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if verified {
+				result.Keys = append(result.Keys, fingerprints[i])
+			}
+		}
+
+		switch *policy {
+		case "any":
+			result.Verified = len(result.Keys) > 0
+		case "all":
+			result.Verified = len(result.Keys) == len(publicKeys)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// verifyWithKey returns true if the given digest is signed by the given
+// public key, false if cosign finds no signature matching the key, or an error
+// if cosign fails otherwise (e.g. registry or network errors)
+func (f *Cosign) verifyWithKey(
+	ctx context.Context,
+	// Cosign container
+	ctr *dagger.Container,
+	// Cosign public key
+	publicKey *dagger.File,
+	// additional cosign verify arguments (i.e. registry authentication,
+	// referrers mode)
+	args []string,
+	// Container image digest to verify
+	digest string,
+) (bool, error) {
+	const publicKeyPath = "/tmp/cosign.pub"
+	cmd := append(
+		[]string{"cosign", "verify", digest, "--key", publicKeyPath},
+		args...,
+	)
+
+	ctr = ctr.
+		WithMountedFile(publicKeyPath, publicKey).
+		WithExec(cmd, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	exitCode, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return false, err
+	}
+	if exitCode == 0 {
+		return true, nil
+	}
+
+	stderr, err := ctr.Stderr(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range unsignedErrors {
+		if strings.Contains(strings.ToLower(stderr), e) {
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf(
+		"error verifying '%s': %s",
+		digest,
+		strings.TrimSpace(stderr),
+	)
+}
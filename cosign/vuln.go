@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"dagger/cosign/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	scannerGrype = "grype"
+	scannerTrivy = "trivy"
+	// path the offline vulnerability database is mounted at
+	vulnDbPath = "/tmp/vulndb"
+	// path the Docker config is mounted at for scanners
+	scannerDockerConfigPath = "/tmp/docker"
+)
+
+// VulnScanSummary represents the summary of a vulnerability scan of a
+// container image digest, attested with cosign
+type VulnScanSummary struct {
+	// Container image digest scanned
+	Digest string
+	// Scanner used, one of: grype, trivy
+	Scanner string
+	// Version of the scanner used
+	ScannerVersion string
+	// Number of vulnerabilities by severity
+	Critical   int
+	High       int
+	Medium     int
+	Low        int
+	Negligible int
+	Unknown    int
+	// Total number of vulnerabilities
+	Total int
+	// Output of each cosign attestation
+	Attestations []string
+	// Cosign container image used, pinned by digest
+	CosignImage string
+	// Version of cosign used
+	CosignVersion string
+}
+
+// vulnPredicate represents the cosign vulnerability scan predicate
+//
+// See https://github.com/sigstore/cosign/blob/main/specs/COSIGN_VULN_ATTESTATION_SPEC.md
+type vulnPredicate struct {
+	Invocation struct {
+		Parameters any    `json:"parameters"`
+		Uri        string `json:"uri"`
+		EventID    string `json:"event_id"`
+		BuilderID  string `json:"builder.id"`
+	} `json:"invocation"`
+	Scanner struct {
+		Uri     string `json:"uri"`
+		Version string `json:"version"`
+		Db      struct {
+			Uri     string `json:"uri"`
+			Version string `json:"version"`
+		} `json:"db"`
+		Result json.RawMessage `json:"result"`
+	} `json:"scanner"`
+	Metadata struct {
+		ScanStartedOn  string `json:"scanStartedOn"`
+		ScanFinishedOn string `json:"scanFinishedOn"`
+	} `json:"metadata"`
+}
+
+// grypeResult represents the parts of the grype JSON output used
+type grypeResult struct {
+	Matches []struct {
+		Vulnerability struct {
+			Severity string `json:"severity"`
+		} `json:"vulnerability"`
+	} `json:"matches"`
+	Descriptor struct {
+		Version string `json:"version"`
+	} `json:"descriptor"`
+}
+
+// trivyResult represents the parts of the trivy JSON output used
+type trivyResult struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			Severity string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+	Trivy struct {
+		Version string `json:"Version"`
+	} `json:"Trivy"`
+}
+
+// AttestVuln will scan the given Container image digest with the given
+// scanner (grype or trivy), convert the result into a cosign vulnerability
+// predicate and attest it with the given private key(s), returning a summary
+// of the scan
+//
+// If a vulnerability database is provided the scan is run offline against it,
+// it is expected to be the scanner's cache directory, i.e.
+//
+//	grype: the GRYPE_DB_CACHE_DIR contents
+//	trivy: the --cache-dir contents (containing db/trivy.db)
+//
+// See https://github.com/sigstore/cosign/blob/main/specs/COSIGN_VULN_ATTESTATION_SPEC.md
+func (f *Cosign) AttestVuln(
+	ctx context.Context,
+	// Container image digest to scan and attest
+	digest string,
+	// Cosign private key
+	privateKey *dagger.Secret,
+	// Cosign password, if the private key is encrypted with one
+	//+optional
+	password *dagger.Secret,
+	// additional Cosign private keys to attest with (e.g. during key rotation)
+	//+optional
+	additionalPrivateKeys []*dagger.Secret,
+	// passwords for the additional Cosign private keys, matched by index
+	//+optional
+	additionalPasswords []*dagger.Secret,
+	// Mount the private keys as secret files rather than environment variables
+	//+optional
+	//+default=false
+	privateKeyAsFile bool,
+	// Vulnerability scanner, one of: grype, trivy
+	//+optional
+	//+default="grype"
+	scanner *string,
+	// Vulnerability scanner container image, defaults to the scanner's
+	// official image
+	//+optional
+	scannerImage *string,
+	// Offline vulnerability database
+	//+optional
+	database *dagger.Directory,
+	// Registry referrers mode to store attestations with, one of:
+	// legacy (sha256-<digest>.att tags), oci-1-1 (OCI 1.1 referrers API)
+	//+optional
+	//+default="legacy"
+	registryReferrersMode *string,
+	// registry username
+	//+optional
+	registryUsername *string,
+	// registry password
+	//+optional
+	registryPassword *dagger.Secret,
+	// Docker config
+	//+optional
+	dockerConfig *dagger.File,
+	// Cosign container image (defaults to chainguard/cosign)
+	//+optional
+	cosignImage *string,
+	// Cosign container image user
+	//+optional
+	//+default="nonroot"
+	cosignUser *string,
+) (*VulnScanSummary, error) {
+	keys, err := signingKeys(
+		privateKey,
+		password,
+		additionalPrivateKeys,
+		additionalPasswords,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	regArgs, err := registryArgs(ctx, registryUsername, registryPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := f.pinCosignImage(
+		ctx,
+		cosignImageOrDefault(cosignImage),
+		*cosignUser,
+		false,
+		"",
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	scanCtr, err := scannerCtr(
+		*scanner,
+		scannerImage,
+		database,
+		registryUsername,
+		registryPassword,
+		dockerConfig,
+		digest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now().UTC()
+	result, err := scanCtr.Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning '%s' with %s: %w", digest, *scanner, err)
+	}
+	finished := time.Now().UTC()
+
+	summary, err := vulnScanSummary(*scanner, digest, result)
+	if err != nil {
+		return nil, err
+	}
+
+	predicate := vulnPredicate{}
+	predicate.Scanner.Uri = scannerUri(*scanner, summary.ScannerVersion)
+	predicate.Scanner.Version = summary.ScannerVersion
+	predicate.Scanner.Result = json.RawMessage(result)
+	predicate.Metadata.ScanStartedOn = started.Format(time.RFC3339)
+	predicate.Metadata.ScanFinishedOn = finished.Format(time.RFC3339)
+	predicateJson, err := json.Marshal(predicate)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering vuln predicate for '%s': %w", digest, err)
+	}
+
+	summary.Attestations, err = f.attest(
+		ctx,
+		&signOpts{
+			keys:                  keys,
+			privateKeyAsFile:      privateKeyAsFile,
+			registryReferrersMode: *registryReferrersMode,
+			registryArgs:          regArgs,
+			dockerConfig:          dockerConfig,
+			cosignImage:           pinned.ref,
+			cosignUser:            *cosignUser,
+		},
+		dag.File("vuln.json", string(predicateJson)),
+		"vuln",
+		digest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.CosignImage = pinned.ref
+	summary.CosignVersion = pinned.version
+
+	return summary, nil
+}
+
+// scannerCtr returns the scanner Container executing a JSON vulnerability
+// scan of the given digest
+func scannerCtr(
+	// Vulnerability scanner, one of: grype, trivy
+	scanner string,
+	// Vulnerability scanner container image
+	scannerImage *string,
+	// Offline vulnerability database
+	database *dagger.Directory,
+	// registry username
+	registryUsername *string,
+	// registry password
+	registryPassword *dagger.Secret,
+	// Docker config
+	dockerConfig *dagger.File,
+	// Container image digest to scan
+	digest string,
+) (*dagger.Container, error) {
+	image := ""
+	switch scanner {
+	case scannerGrype:
+		image = "anchore/grype:latest"
+	case scannerTrivy:
+		image = "aquasec/trivy:latest"
+	default:
+		return nil, fmt.Errorf(
+			"unsupported scanner '%s', must be one of: %s, %s",
+			scanner,
+			scannerGrype,
+			scannerTrivy,
+		)
+	}
+	if scannerImage != nil {
+		image = *scannerImage
+	}
+
+	ctr := ctrWithDockerConfigEnv(dag.Container().From(image), dockerConfig)
+	if database != nil {
+		ctr = ctr.WithMountedDirectory(vulnDbPath, database)
+	}
+
+	switch scanner {
+	case scannerGrype:
+		ctr = anchoreCtrWithRegistryAuth(
+			ctr,
+			"GRYPE",
+			registryUsername,
+			registryPassword,
+			digest,
+		)
+		if database != nil {
+			ctr = ctr.
+				WithEnvVariable("GRYPE_DB_CACHE_DIR", vulnDbPath).
+				WithEnvVariable("GRYPE_DB_AUTO_UPDATE", "false").
+				WithEnvVariable("GRYPE_DB_VALIDATE_AGE", "false")
+		}
+
+		return ctr.WithExec([]string{
+			"/grype", fmt.Sprintf("registry:%s", digest), "--output", "json",
+		}), nil
+	}
+
+	if registryUsername != nil && registryPassword != nil {
+		ctr = ctr.
+			WithEnvVariable("TRIVY_USERNAME", *registryUsername).
+			WithSecretVariable("TRIVY_PASSWORD", registryPassword)
+	}
+	cmd := []string{
+		"trivy", "image", digest,
+		"--image-src", "remote",
+		"--format", "json",
+		"--quiet",
+	}
+	if database != nil {
+		cmd = append(cmd,
+			"--cache-dir", vulnDbPath,
+			"--skip-db-update",
+			"--skip-java-db-update",
+			"--offline-scan",
+		)
+	}
+
+	return ctr.WithExec(cmd), nil
+}
+
+// vulnScanSummary returns the summary of the given scanner JSON result
+func vulnScanSummary(
+	// Vulnerability scanner, one of: grype, trivy
+	scanner string,
+	// Container image digest scanned
+	digest string,
+	// scanner JSON result
+	result string,
+) (*VulnScanSummary, error) {
+	summary := &VulnScanSummary{Digest: digest, Scanner: scanner}
+	severities := []string{}
+
+	switch scanner {
+	case scannerGrype:
+		grype := grypeResult{}
+		if err := json.Unmarshal([]byte(result), &grype); err != nil {
+			return nil, fmt.Errorf("error parsing grype result for '%s': %w", digest, err)
+		}
+		summary.ScannerVersion = grype.Descriptor.Version
+		for _, m := range grype.Matches {
+			severities = append(severities, m.Vulnerability.Severity)
+		}
+	case scannerTrivy:
+		trivy := trivyResult{}
+		if err := json.Unmarshal([]byte(result), &trivy); err != nil {
+			return nil, fmt.Errorf("error parsing trivy result for '%s': %w", digest, err)
+		}
+		summary.ScannerVersion = trivy.Trivy.Version
+		for _, r := range trivy.Results {
+			for _, v := range r.Vulnerabilities {
+				severities = append(severities, v.Severity)
+			}
+		}
+	}
+
+	for _, s := range severities {
+		switch strings.ToLower(s) {
+		case "critical":
+			summary.Critical++
+		case "high":
+			summary.High++
+		case "medium":
+			summary.Medium++
+		case "low":
+			summary.Low++
+		case "negligible":
+			summary.Negligible++
+		default:
+			summary.Unknown++
+		}
+	}
+	summary.Total = len(severities)
+
+	return summary, nil
+}
+
+// scannerUri returns the package URL of the given scanner & version
+func scannerUri(scanner string, version string) string {
+	org := "anchore"
+	if scanner == scannerTrivy {
+		org = "aquasecurity"
+	}
+	if version == "" {
+		return fmt.Sprintf("pkg:github/%s/%s", org, scanner)
+	}
+
+	return fmt.Sprintf("pkg:github/%s/%s@%s", org, scanner, version)
+}
+
+// ctrWithDockerConfigEnv returns the given Container with the Docker config
+// (if any) mounted and DOCKER_CONFIG set to its directory, for tools which
+// do not run as a known user (i.e. scanners)
+func ctrWithDockerConfigEnv(
+	ctr *dagger.Container,
+	// Docker config
+	dockerConfig *dagger.File,
+) *dagger.Container {
+	if dockerConfig == nil {
+		return ctr
+	}
+
+	return ctr.
+		WithEnvVariable("DOCKER_CONFIG", scannerDockerConfigPath).
+		WithMountedFile(
+			fmt.Sprintf("%s/config.json", scannerDockerConfigPath),
+			dockerConfig,
+		)
+}
+
+// anchoreCtrWithRegistryAuth returns the given Container with the registry
+// authentication environment variables set for the Anchore tool (grype,
+// syft) with the given environment variable prefix (i.e. GRYPE, SYFT)
+func anchoreCtrWithRegistryAuth(
+	ctr *dagger.Container,
+	// environment variable prefix
+	prefix string,
+	// registry username
+	registryUsername *string,
+	// registry password
+	registryPassword *dagger.Secret,
+	// Container image reference the registry is determined from
+	ref string,
+) *dagger.Container {
+	if registryUsername == nil || registryPassword == nil {
+		return ctr
+	}
+
+	return ctr.
+		WithEnvVariable(prefix+"_REGISTRY_AUTH_AUTHORITY", registryHost(ref)).
+		WithEnvVariable(prefix+"_REGISTRY_AUTH_USERNAME", *registryUsername).
+		WithSecretVariable(prefix+"_REGISTRY_AUTH_PASSWORD", registryPassword)
+}
+
+// registryHost returns the registry host of the given image reference,
+// defaulting to Docker Hub if the reference has no registry host
+func registryHost(ref string) string {
+	host, _, found := strings.Cut(ref, "/")
+	if found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return host
+	}
+
+	return "index.docker.io"
+}
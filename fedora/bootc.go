@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	labelBootc          = "containers.bootc"
+	labelOstreeBootable = "ostree.bootable"
+
+	lintSeverityError   = "error"
+	lintSeverityWarning = "warning"
+)
+
+// bootc container lint finding, e.g.:
+//
+//	Lint warning: var-log: Found non-empty logfile: /var/log/dnf5.log
+//	Lint failed: etc-usretc: Found /usr/etc
+var bootcLintFindingRegexp = regexp.MustCompile(
+	`(?m)^(?:Lint (?:warning|failed|failure|error)|(?:Failed|Warning) lint):\s*([\w.-]+):\s*(.*)$`,
+)
+
+// bootc (clap) argument parsing error, e.g.:
+//
+//	error: unexpected argument '--skip' found
+//	error: Found argument '--fatal-warnings' which wasn't expected
+var bootcUnsupportedFlagRegexp = regexp.MustCompile(
+	`(?m)^error: (?:unexpected argument|Found argument) '--`,
+)
+
+// LintFinding represents a finding of `bootc container lint`
+type LintFinding struct {
+	// name of the lint
+	Lint string
+	// severity of the finding, one of: error, warning
+	Severity string
+	Message  string
+}
+
+// BootcLintResult represents the result of `bootc container lint`
+type BootcLintResult struct {
+	// true if no lint failed
+	Passed   bool
+	Findings []*LintFinding
+	// output of `bootc container lint`
+	Output string
+}
+
+// Errors returns the findings of failed lints
+func (r *BootcLintResult) Errors() []*LintFinding {
+	return r.findings(lintSeverityError)
+}
+
+// Warnings returns the findings of lint warnings
+func (r *BootcLintResult) Warnings() []*LintFinding {
+	return r.findings(lintSeverityWarning)
+}
+
+// findings returns the findings of the given severity
+func (r *BootcLintResult) findings(severity string) []*LintFinding {
+	findings := []*LintFinding{}
+	for _, finding := range r.Findings {
+		if finding.Severity == severity {
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings
+}
+
+// WithBootcLint will run `bootc container lint` as the final step of
+// generating the Container image, failing if any lint fails. By default lint
+// is run on bootc images (containers.bootc label).
+//
+// See https://bootc-dev.github.io/bootc/bootc-images.html
+func (f *Fedora) WithBootcLint(
+	ctx context.Context,
+	// If false, lint will not be run, even on bootc images
+	// +optional
+	// +default=true
+	enabled bool,
+	// If true, lint warnings will fail the generated Container image
+	// +optional
+	fatalWarnings bool,
+	// lints to skip (e.g. var-log)
+	// +optional
+	skip []string,
+) *Fedora {
+	f.BootcLintEnabled = &enabled
+	f.BootcLintFatalWarnings = fatalWarnings
+	f.BootcLintSkipped = append(f.BootcLintSkipped, skip...)
+
+	return f
+}
+
+// WithOstreeContainerCommit will run `ostree container commit` as the final
+// step of generating the Container image, cleaning up /var & /tmp and
+// validating the image. By default it is run on ostree-based images
+// (ostree.bootable label) which are not bootc images.
+//
+// See https://coreos.github.io/rpm-ostree/container/
+func (f *Fedora) WithOstreeContainerCommit(
+	ctx context.Context,
+	// If false, ostree container commit will not be run, even on
+	// ostree-based images
+	// +optional
+	// +default=true
+	enabled bool,
+) *Fedora {
+	f.OstreeContainerCommit = &enabled
+
+	return f
+}
+
+// BootcLint runs `bootc container lint` against the generated Container image
+// and returns the findings, rather than failing on failed lints
+func (f *Fedora) BootcLint(ctx context.Context) (*BootcLintResult, error) {
+	ctr, err := f.ctrFrom(
+		ctx,
+		f.ContainerAddress(f.Registry, f.Org, f.Variant, f.Suffix, f.Tag),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.bootcLint(ctx, f.ctrWithOstreeContainerCommit(ctx, ctr))
+}
+
+// bootcLint returns the result of `bootc container lint` against the given
+// Container
+func (f *Fedora) bootcLint(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*BootcLintResult, error) {
+	// flags are only passed when needed, as older bootc versions lack them
+	cmd := []string{"bootc", "container", "lint"}
+	if f.BootcLintFatalWarnings {
+		cmd = append(cmd, "--fatal-warnings")
+	}
+	for _, s := range f.BootcLintSkipped {
+		cmd = append(cmd, fmt.Sprintf("--skip=%s", s))
+	}
+
+	ctr = ctr.WithExec(cmd, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	exitCode, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := ctr.CombinedOutput(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 && bootcUnsupportedFlagRegexp.MatchString(output) {
+		return nil, fmt.Errorf(
+			"bootc container lint options (fatal warnings, skip) not supported by the bootc version of the image: %s",
+			strings.TrimSpace(output),
+		)
+	}
+
+	result := &BootcLintResult{
+		Passed:   exitCode == 0,
+		Findings: parseBootcLint(output),
+		Output:   output,
+	}
+	if f.BootcLintFatalWarnings && len(result.Warnings()) > 0 {
+		result.Passed = false
+	}
+
+	return result, nil
+}
+
+// parseBootcLint returns the findings in the given `bootc container lint`
+// output
+func parseBootcLint(output string) []*LintFinding {
+	findings := []*LintFinding{}
+	for _, m := range bootcLintFindingRegexp.FindAllStringSubmatch(output, -1) {
+		severity := lintSeverityError
+		if prefix, _, _ := strings.Cut(m[0], ":"); strings.Contains(strings.ToLower(prefix), "warning") {
+			severity = lintSeverityWarning
+		}
+
+		findings = append(findings, &LintFinding{
+			Lint:     m[1],
+			Severity: severity,
+			Message:  strings.TrimSpace(m[2]),
+		})
+	}
+
+	return findings
+}
+
+// ctrWithBootcFinalized runs the final steps for bootc and ostree-based
+// images on the given Container as set by WithBootcLint &
+// WithOstreeContainerCommit, or detected from the Container labels
+func (f *Fedora) ctrWithBootcFinalized(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*dagger.Container, error) {
+	ctr = f.ctrWithOstreeContainerCommit(ctx, ctr)
+
+	lint := isBootc(ctx, ctr)
+	if f.BootcLintEnabled != nil {
+		lint = *f.BootcLintEnabled
+	}
+	if !lint {
+		return ctr, nil
+	}
+
+	// lint is run against, but not kept in, the generated Container image
+	result, err := f.bootcLint(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.Passed {
+		return nil, &bootcLintError{result: result}
+	}
+
+	return ctr, nil
+}
+
+// ctrWithOstreeContainerCommit returns the given Container with
+// `ostree container commit` run, as set by WithOstreeContainerCommit or if it
+// is an ostree-based image which is not a bootc image
+func (f *Fedora) ctrWithOstreeContainerCommit(
+	ctx context.Context,
+	ctr *dagger.Container,
+) *dagger.Container {
+	commit := isOstreeBootable(ctx, ctr) && !isBootc(ctx, ctr)
+	if f.OstreeContainerCommit != nil {
+		commit = *f.OstreeContainerCommit
+	}
+
+	if commit {
+		return ctr.WithExec([]string{"ostree", "container", "commit"})
+	}
+
+	return ctr
+}
+
+// bootcLintError is returned when `bootc container lint` fails
+type bootcLintError struct {
+	result *BootcLintResult
+}
+
+func (e *bootcLintError) Error() string {
+	findings := e.result.Errors()
+	if len(findings) == 0 {
+		findings = e.result.Warnings()
+	}
+	if len(findings) == 0 {
+		return fmt.Sprintf("bootc container lint failed:\n%s", e.result.Output)
+	}
+
+	lints := []string{}
+	for _, finding := range findings {
+		lints = append(lints, fmt.Sprintf(
+			"%s (%s): %s",
+			finding.Lint,
+			finding.Severity,
+			finding.Message,
+		))
+	}
+
+	return fmt.Sprintf("bootc container lint failed: %s", strings.Join(lints, "; "))
+}
+
+// isBootc returns true if the given Container is a bootc image
+func isBootc(ctx context.Context, ctr *dagger.Container) bool {
+	return labelEnabled(ctx, ctr, labelBootc)
+}
+
+// isOstreeBootable returns true if the given Container is an ostree-based
+// image
+func isOstreeBootable(ctx context.Context, ctr *dagger.Container) bool {
+	return labelEnabled(ctx, ctr, labelOstreeBootable)
+}
+
+// labelEnabled returns true if the given label of the Container is set to
+// true or 1
+func labelEnabled(ctx context.Context, ctr *dagger.Container, label string) bool {
+	value, _ := ctr.Label(ctx, label)
+
+	return value == "true" || value == "1"
+}
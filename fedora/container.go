@@ -67,6 +67,21 @@ func (f *Fedora) ContainerFrom(
 	ctx context.Context,
 	// base container image to pull FROM
 	from string,
+) (*dagger.Container, error) {
+	ctr, err := f.ctrFrom(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.ctrWithBootcFinalized(ctx, ctr)
+}
+
+// ctrFrom returns the Fedora container, as defined by the Fedora object,
+// prior to the final steps for bootc and ostree-based images
+func (f *Fedora) ctrFrom(
+	ctx context.Context,
+	// base container image to pull FROM
+	from string,
 ) (*dagger.Container, error) {
 	ctr := dag.
 		Container().
@@ -95,7 +110,7 @@ func (f *Fedora) ContainerFrom(
 		ctr = ctr.WithExec(cmd)
 	}
 
-	if f.PackagesInstalled != nil || f.PackagesRemoved != nil || f.PackagesSwapped != nil {
+	if f.packagesChanged() {
 		var err error
 		ctr, err = f.ctrWithPackagesInstalledAndRemoved(ctx, ctr)
 		if err != nil {
@@ -216,20 +231,19 @@ func (f *Fedora) ctrWithLabels(ctr *dagger.Container) *dagger.Container {
 	return ctr
 }
 
-// ctrWithExec wraps Container.WithExec allowing the command and args to be
-// separated
-func (f *Fedora) ctrWithExec(
-	ctr *dagger.Container,
-	// command to be executed
-	command []string,
-	// arguments to be passed to the given command
-	args ...string,
-) *dagger.Container {
-	if args != nil {
-		command = append(command, args...)
-	}
-
-	return ctr.WithExec(command)
+// packagesChanged returns true if any packages or package groups are to be
+// installed, removed, replaced or swapped, or a package lock is to be
+// installed
+func (f *Fedora) packagesChanged() bool {
+	return f.PackageGroupsInstalled != nil ||
+		f.PackageGroupsRemoved != nil ||
+		f.PackagesInstalled != nil ||
+		f.LocalPackageFiles != nil ||
+		f.LocalPackageDirectories != nil ||
+		f.PackagesRemoved != nil ||
+		f.PackagesReplaced != nil ||
+		f.PackagesSwapped != nil ||
+		f.PackageLockFile != nil
 }
 
 // ctrExecScripts adds the given scripts (files) to the Fedora container
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	packageChangeAdded      = "added"
+	packageChangeRemoved    = "removed"
+	packageChangeUpgraded   = "upgraded"
+	packageChangeDowngraded = "downgraded"
+)
+
+// PackageChange represents a package changed between the base image and the
+// generated Container image
+type PackageChange struct {
+	Name string
+	Arch string
+	// [epoch:]version-release in the base image, empty if added
+	From string
+	// [epoch:]version-release in the generated Container image, empty if
+	// removed
+	To string
+}
+
+// PackageDiff represents the packages changed between the base image and the
+// generated Container image
+type PackageDiff struct {
+	Added      []*PackageChange
+	Removed    []*PackageChange
+	Upgraded   []*PackageChange
+	Downgraded []*PackageChange
+}
+
+// Markdown returns the package changes as a Markdown table
+func (d *PackageDiff) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("| Change | Package | Arch | From | To |\n")
+	sb.WriteString("| --- | --- | --- | --- | --- |\n")
+
+	for _, changes := range []struct {
+		change   string
+		packages []*PackageChange
+	}{
+		{packageChangeAdded, d.Added},
+		{packageChangeRemoved, d.Removed},
+		{packageChangeUpgraded, d.Upgraded},
+		{packageChangeDowngraded, d.Downgraded},
+	} {
+		for _, p := range changes.packages {
+			fmt.Fprintf(&sb, "| %s | %s | %s | %s | %s |\n",
+				changes.change,
+				p.Name,
+				p.Arch,
+				p.From,
+				p.To,
+			)
+		}
+	}
+
+	return sb.String()
+}
+
+// PackageDiff compares the rpm database of the base image with that of the
+// generated Container image and returns the packages added, removed,
+// upgraded and downgraded
+func (f *Fedora) PackageDiff(ctx context.Context) (*PackageDiff, error) {
+	base, err := rpmPackages(ctx, dag.Container().From(f.BaseImage))
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, err := f.Container(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	generated, err := rpmPackages(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffPackages(base, generated), nil
+}
+
+// diffPackages returns the changes from the base packages to the generated
+// packages. Packages installed at more than one version (i.e. install-only
+// packages such as the kernel) are reported as added & removed versions.
+func diffPackages(base []*rpmPackage, generated []*rpmPackage) *PackageDiff {
+	diff := &PackageDiff{
+		Added:      []*PackageChange{},
+		Removed:    []*PackageChange{},
+		Upgraded:   []*PackageChange{},
+		Downgraded: []*PackageChange{},
+	}
+
+	baseByKey := packagesByKey(base)
+	generatedByKey := packagesByKey(generated)
+
+	keys := []string{}
+	for k := range baseByKey {
+		keys = append(keys, k)
+	}
+	for k := range generatedByKey {
+		if _, ok := baseByKey[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		from, to := baseByKey[k], generatedByKey[k]
+
+		if len(from) == 1 && len(to) == 1 {
+			change := &PackageChange{
+				Name: to[0].Name,
+				Arch: to[0].Arch,
+				From: from[0].evr(),
+				To:   to[0].evr(),
+			}
+			switch rc := compareEvr(from[0], to[0]); {
+			case rc < 0:
+				diff.Upgraded = append(diff.Upgraded, change)
+			case rc > 0:
+				diff.Downgraded = append(diff.Downgraded, change)
+			}
+
+			continue
+		}
+
+		for _, p := range to {
+			if !containsEvr(from, p) {
+				diff.Added = append(diff.Added, &PackageChange{
+					Name: p.Name,
+					Arch: p.Arch,
+					To:   p.evr(),
+				})
+			}
+		}
+		for _, p := range from {
+			if !containsEvr(to, p) {
+				diff.Removed = append(diff.Removed, &PackageChange{
+					Name: p.Name,
+					Arch: p.Arch,
+					From: p.evr(),
+				})
+			}
+		}
+	}
+
+	return diff
+}
+
+// packagesByKey returns the given packages grouped by name.arch
+func packagesByKey(packages []*rpmPackage) map[string][]*rpmPackage {
+	byKey := map[string][]*rpmPackage{}
+	for _, p := range packages {
+		byKey[p.key()] = append(byKey[p.key()], p)
+	}
+
+	return byKey
+}
+
+// containsEvr returns true if the given packages contain the epoch, version
+// & release of the given package
+func containsEvr(packages []*rpmPackage, p *rpmPackage) bool {
+	return slices.ContainsFunc(packages, func(q *rpmPackage) bool {
+		return compareEvr(q, p) == 0
+	})
+}
+
+// compareEvr compares the epoch, version & release of the given packages as
+// rpm does, returning -1 if a is older than b, 0 if equal and 1 if newer
+func compareEvr(a *rpmPackage, b *rpmPackage) int {
+	epochA, epochB := a.Epoch, b.Epoch
+	if epochA == "" {
+		epochA = "0"
+	}
+	if epochB == "" {
+		epochB = "0"
+	}
+
+	if rc := rpmvercmp(epochA, epochB); rc != 0 {
+		return rc
+	}
+	if rc := rpmvercmp(a.Version, b.Version); rc != 0 {
+		return rc
+	}
+
+	return rpmvercmp(a.Release, b.Release)
+}
+
+// rpmvercmp compares the given version (or release) strings as rpm does,
+// returning -1 if a is older than b, 0 if equal and 1 if newer
+//
+// Versions are compared segment by segment, numeric segments numerically and
+// alphabetic segments lexically, where numeric segments are newer than
+// alphabetic ones, ~ sorts before anything (pre-releases) and ^ after the
+// version it follows (snapshots)
+//
+// See https://github.com/rpm-software-management/rpm/blob/master/rpmio/rpmvercmp.cc
+func rpmvercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isAlpha := func(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+	isSeparator := func(c byte) bool { return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^' }
+
+	for len(a) > 0 || len(b) > 0 {
+		for len(a) > 0 && isSeparator(a[0]) {
+			a = a[1:]
+		}
+		for len(b) > 0 && isSeparator(b[0]) {
+			b = b[1:]
+		}
+
+		// ~ sorts before everything, including the end of the version
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+
+			continue
+		}
+
+		// ^ sorts after the end of the version, but before anything else
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if len(a) == 0 {
+				return -1
+			}
+			if len(b) == 0 {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+
+			continue
+		}
+
+		if len(a) == 0 || len(b) == 0 {
+			break
+		}
+
+		isNum := isDigit(a[0])
+		segment := func(s string) (string, string) {
+			i := 0
+			for i < len(s) && ((isNum && isDigit(s[i])) || (!isNum && isAlpha(s[i]))) {
+				i++
+			}
+
+			return s[:i], s[i:]
+		}
+
+		var segA, segB string
+		segA, a = segment(a)
+		segB, b = segment(b)
+
+		// segments of different types, numeric is newer
+		if len(segB) == 0 {
+			if isNum {
+				return 1
+			}
+
+			return -1
+		}
+
+		if isNum {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+
+				return -1
+			}
+		}
+
+		if rc := strings.Compare(segA, segB); rc != 0 {
+			return rc
+		}
+	}
+
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return -1
+	}
+
+	return 1
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"fmt"
+	"strings"
+)
+
+// dnf represents the dnf package manager, with the command syntax of a dnf
+// major version
+type dnf struct {
+	installCmd      []string
+	removeCmd       []string
+	upgradeCmd      []string
+	groupInstallCmd []string
+	groupRemoveCmd  []string
+	swapCmd         []string
+	cleanCmd        []string
+	// groupSpec returns the argument for the given package group
+	groupSpec func(group string) string
+}
+
+// install the given packages
+func (d *dnf) install(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, d.installCmd, packages), nil
+}
+
+// remove the given packages
+func (d *dnf) remove(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, d.removeCmd, packages), nil
+}
+
+// replace replaces installed packages with the given package versions, dnf
+// installs the given version regardless of the version installed
+func (d *dnf) replace(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, d.installCmd, packages), nil
+}
+
+// swap removes and installs the given packages in one transaction
+func (d *dnf) swap(
+	ctx context.Context,
+	ctr *dagger.Container,
+	remove, install string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, d.swapCmd, []string{remove, install}), nil
+}
+
+// groupInstall installs the given package groups
+func (d *dnf) groupInstall(
+	ctx context.Context,
+	ctr *dagger.Container,
+	groups []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, d.groupInstallCmd, d.groups(groups)), nil
+}
+
+// groupRemove removes the given package groups
+func (d *dnf) groupRemove(
+	ctx context.Context,
+	ctr *dagger.Container,
+	groups []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, d.groupRemoveCmd, d.groups(groups)), nil
+}
+
+// upgrade upgrades all installed packages
+func (d *dnf) upgrade(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*dagger.Container, error) {
+	return ctr.WithExec(d.upgradeCmd), nil
+}
+
+// clean removes any cached package metadata
+func (d *dnf) clean(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*dagger.Container, error) {
+	return ctr.WithExec(d.cleanCmd), nil
+}
+
+// groups returns the arguments for the given package groups
+func (d *dnf) groups(groups []string) []string {
+	specs := []string{}
+	for _, g := range groups {
+		specs = append(specs, d.groupSpec(g))
+	}
+
+	return specs
+}
+
+// dnf4 commands
+//
+//	dnf -y group install "Development Tools"
+var dnf4 = &dnf{
+	installCmd:      []string{"dnf", "-y", "install"},
+	removeCmd:       []string{"dnf", "-y", "remove"},
+	upgradeCmd:      []string{"dnf", "-y", "upgrade"},
+	groupInstallCmd: []string{"dnf", "-y", "group", "install"},
+	groupRemoveCmd:  []string{"dnf", "-y", "group", "remove"},
+	swapCmd:         []string{"dnf", "-y", "swap"},
+	cleanCmd:        []string{"dnf", "clean", "all"},
+	groupSpec: func(group string) string {
+		return group
+	},
+}
+
+// dnf5 commands
+//
+// Groups are installed & removed as `@<group>` package specs (id or name),
+// rather than via the dnf5 group commands, whose removal semantics differ
+// from dnf4. swap is run with --allowerasing so, as with dnf4, a
+// package others depend on can be replaced.
+//
+//	dnf5 -y install "@Development Tools"
+//
+// See https://dnf5.readthedocs.io/en/latest/changes_from_dnf4.7.html
+var dnf5 = &dnf{
+	installCmd:      []string{"dnf5", "-y", "install"},
+	removeCmd:       []string{"dnf5", "-y", "remove"},
+	upgradeCmd:      []string{"dnf5", "-y", "upgrade"},
+	groupInstallCmd: []string{"dnf5", "-y", "install"},
+	groupRemoveCmd:  []string{"dnf5", "-y", "remove"},
+	swapCmd:         []string{"dnf5", "-y", "swap", "--allowerasing"},
+	cleanCmd:        []string{"dnf5", "clean", "all"},
+	groupSpec:       dnf5GroupSpec,
+}
+
+// dnf5GroupSpec returns the `@<group>` package spec for the given group id
+// or name, which dnf5 resolves by either, e.g. "Development Tools" ->
+// @Development Tools
+func dnf5GroupSpec(group string) string {
+	if strings.HasPrefix(group, "@") {
+		return group
+	}
+
+	return fmt.Sprintf("@%s", group)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const packageLockFileName = "packages.lock.json"
+
+// packageLock represents a lockfile of the exact packages installed on a
+// generated Container image
+type packageLock struct {
+	BaseImage      string        `json:"baseImage"`
+	ReleaseVersion string        `json:"releaseVersion,omitempty"`
+	Packages       []*rpmPackage `json:"packages"`
+}
+
+// PackageLock returns a lockfile (JSON) of the exact packages (name, epoch,
+// version, release & arch) installed on the generated Container image, along
+// with the repository each was installed from, to reproduce the image with
+// WithPackageLock
+func (f *Fedora) PackageLock(ctx context.Context) (*dagger.File, error) {
+	packages, err := f.containerPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// licenses are reported by PackageManifest, not locked
+	for _, p := range packages {
+		p.License = ""
+	}
+
+	lock := &packageLock{BaseImage: f.BaseImage, Packages: packages}
+	if f.ReleaseVersion != nil {
+		lock.ReleaseVersion = *f.ReleaseVersion
+	}
+
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error rendering package lock: %w", err)
+	}
+
+	return dag.File(packageLockFileName, string(data)), nil
+}
+
+// WithPackageLock will install exactly the package versions of the given
+// lockfile, as returned by PackageLock, rather than the latest available. The
+// generated Container image will fail if any locked package version cannot
+// be installed.
+//
+// The locked versions are installed in place of the packages to be installed,
+// which must be in the lock. Package groups cannot be installed with a lock,
+// and the generated Container image fails if it has any package not in the
+// lock (e.g. a dependency of local or swapped packages).
+//
+//	note: installed packages are not upgraded
+func (f *Fedora) WithPackageLock(
+	ctx context.Context,
+	// lockfile, as returned by PackageLock
+	lock *dagger.File,
+) *Fedora {
+	f.PackageLockFile = lock
+
+	return f
+}
+
+// packageLock returns the lockfile set by WithPackageLock, or nil if not set
+func (f *Fedora) packageLock(ctx context.Context) (*packageLock, error) {
+	if f.PackageLockFile == nil {
+		return nil, nil
+	}
+
+	contents, err := f.PackageLockFile.Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading package lock: %w", err)
+	}
+
+	lock := &packageLock{}
+	if err := json.Unmarshal([]byte(contents), lock); err != nil {
+		return nil, fmt.Errorf("error parsing package lock: %w", err)
+	}
+
+	return lock, nil
+}
+
+// validate returns an error if any of the given package specs (name,
+// name.arch or nevra) is not in the lock
+func (l *packageLock) validate(specs []string) error {
+	missing := []string{}
+	for _, spec := range specs {
+		if !slices.ContainsFunc(l.Packages, func(p *rpmPackage) bool {
+			return spec == p.Name || spec == p.key() || spec == p.nevra()
+		}) {
+			missing = append(missing, spec)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf(
+			"packages not in package lock, regenerate it with PackageLock: %s",
+			strings.Join(missing, ", "),
+		)
+	}
+
+	return nil
+}
+
+// ctrWithPackageLock returns the given Container with exactly the locked
+// package versions installed with the given package manager, per name.arch:
+// packages not installed are installed, packages installed at another version
+// are replaced (i.e. `rpm-ostree override replace` for base image packages)
+// and any remaining versions not locked (i.e. of install-only packages such
+// as the kernel) are removed.
+// An error is returned if the installed versions do not match the lock
+// afterward.
+func ctrWithPackageLock(
+	ctx context.Context,
+	ctr *dagger.Container,
+	pm packageManager,
+	lock *packageLock,
+) (*dagger.Container, error) {
+	installed, err := rpmPackages(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	installedByKey := packagesByKey(installed)
+
+	install, replace := []string{}, []string{}
+	for key, locked := range packagesByKey(lock.Packages) {
+		current := installedByKey[key]
+		for _, p := range locked {
+			if containsEvr(current, p) {
+				continue
+			}
+
+			if len(current) == 0 {
+				install = append(install, p.nevra())
+			} else {
+				replace = append(replace, p.nevra())
+			}
+		}
+	}
+	slices.Sort(install)
+	slices.Sort(replace)
+
+	ctr, err = pm.install(ctx, ctr, install)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, err = pm.replace(ctx, ctr, replace)
+	if err != nil {
+		return nil, err
+	}
+
+	// versions remaining alongside the locked versions (i.e. of install-only
+	// packages such as the kernel) are removed
+	installed, err = rpmPackages(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+	installedByKey = packagesByKey(installed)
+
+	remove := []string{}
+	for key, locked := range packagesByKey(lock.Packages) {
+		for _, p := range installedByKey[key] {
+			if !containsEvr(locked, p) {
+				remove = append(remove, p.nevra())
+			}
+		}
+	}
+	slices.Sort(remove)
+
+	ctr, err = pm.remove(ctx, ctr, remove)
+	if err != nil {
+		return nil, err
+	}
+
+	unsatisfied, unlocked, err := unsatisfiedPackages(ctx, ctr, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unsatisfied) > 0 {
+		return nil, fmt.Errorf(
+			"unable to satisfy package lock, installed versions differ: %s",
+			strings.Join(unsatisfied, ", "),
+		)
+	}
+	if len(unlocked) > 0 {
+		return nil, fmt.Errorf(
+			"packages not in package lock installed, regenerate it with PackageLock: %s",
+			strings.Join(unlocked, ", "),
+		)
+	}
+
+	return ctr, nil
+}
+
+// unsatisfiedPackages returns the name.arch of the locked packages whose
+// installed versions on the given Container differ from the locked versions,
+// and the nevra of the installed packages not in the lock
+func unsatisfiedPackages(
+	ctx context.Context,
+	ctr *dagger.Container,
+	lock *packageLock,
+) ([]string, []string, error) {
+	packages, err := rpmPackages(ctx, ctr)
+	if err != nil {
+		return nil, nil, err
+	}
+	installedByKey := packagesByKey(packages)
+	lockedByKey := packagesByKey(lock.Packages)
+
+	unsatisfied := []string{}
+	for key, locked := range lockedByKey {
+		current := installedByKey[key]
+		same := len(current) == len(locked)
+		for _, p := range locked {
+			same = same && containsEvr(current, p)
+		}
+
+		if !same {
+			unsatisfied = append(unsatisfied, key)
+		}
+	}
+	slices.Sort(unsatisfied)
+
+	unlocked := []string{}
+	for _, p := range packages {
+		if _, ok := lockedByKey[p.key()]; !ok {
+			unlocked = append(unlocked, p.nevra())
+		}
+	}
+	slices.Sort(unlocked)
+
+	return unsatisfied, unlocked, nil
+}
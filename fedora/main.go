@@ -83,19 +83,28 @@ type Fedora struct {
 	Date    string
 	Digests []string
 
-	Directories            []*DirectoryFromSource
-	Files                  []*FileFromSource
-	PackageGroupsInstalled []string
-	PackageGroupsRemoved   []string
-	PackagesInstalled      []string
-	PackagesRemoved        []string
-	PackagesSwapped        []Swap
-	Repos                  []*Repo
-	ExecScriptPre          []*dagger.File
-	ExecScriptPost         []*dagger.File
-	ExecPre                [][]string
-	ExecPost               [][]string
-	Labels                 []*ContainerLabel
+	Directories             []*DirectoryFromSource
+	Files                   []*FileFromSource
+	PackageGroupsInstalled  []string
+	PackageGroupsRemoved    []string
+	PackagesInstalled       []string
+	LocalPackageFiles       []*dagger.File
+	LocalPackageDirectories []*dagger.Directory
+	PackagesRemoved         []string
+	PackagesReplaced        []string
+	PackagesSwapped         []Swap
+	PackageManager          *string
+	PackageLockFile         *dagger.File
+	Repos                   []*Repo
+	ExecScriptPre           []*dagger.File
+	ExecScriptPost          []*dagger.File
+	ExecPre                 [][]string
+	ExecPost                [][]string
+	Labels                  []*ContainerLabel
+	BootcLintEnabled        *bool
+	BootcLintFatalWarnings  bool
+	BootcLintSkipped        []string
+	OstreeContainerCommit   *bool
 }
 
 func (f *Fedora) GetBaseImage() string {
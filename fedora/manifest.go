@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"encoding/json"
+	"fmt"
+)
+
+const packageManifestFileName = "packages.json"
+
+// PackageManifest returns the packages installed on the generated Container
+// image (name, epoch, version, release, arch, license & the repository each
+// was installed from) as JSON, from the rpm database of the image
+func (f *Fedora) PackageManifest(ctx context.Context) (*dagger.File, error) {
+	packages, err := f.containerPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(packages, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error rendering package manifest: %w", err)
+	}
+
+	return dag.File(packageManifestFileName, string(data)), nil
+}
+
+// containerPackages returns the packages installed on the generated
+// Container image, along with the repository each was installed from
+func (f *Fedora) containerPackages(ctx context.Context) ([]*rpmPackage, error) {
+	ctr, err := f.Container(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	packages, err := rpmPackages(ctx, ctr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := withRpmRepos(ctx, ctr, packages); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
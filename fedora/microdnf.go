@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"fmt"
+)
+
+// microdnf represents the microdnf package manager of minimal images (e.g.
+// fedora-minimal), which does not support package groups or swap
+type microdnf struct{}
+
+// install the given packages
+func (m *microdnf) install(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, []string{"microdnf", "-y", "install"}, packages), nil
+}
+
+// remove the given packages
+func (m *microdnf) remove(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, []string{"microdnf", "-y", "remove"}, packages), nil
+}
+
+// replace replaces installed packages with the given package versions
+func (m *microdnf) replace(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return m.install(ctx, ctr, packages)
+}
+
+// swap is not supported by microdnf
+func (m *microdnf) swap(
+	ctx context.Context,
+	ctr *dagger.Container,
+	remove, install string,
+) (*dagger.Container, error) {
+	return nil, fmt.Errorf(
+		"unable to swap '%s' for '%s': swap not supported by %s",
+		remove,
+		install,
+		packageManagerMicrodnf,
+	)
+}
+
+// groupInstall is not supported by microdnf
+func (m *microdnf) groupInstall(
+	ctx context.Context,
+	ctr *dagger.Container,
+	groups []string,
+) (*dagger.Container, error) {
+	return m.groupsUnsupported(ctr, groups)
+}
+
+// groupRemove is not supported by microdnf
+func (m *microdnf) groupRemove(
+	ctx context.Context,
+	ctr *dagger.Container,
+	groups []string,
+) (*dagger.Container, error) {
+	return m.groupsUnsupported(ctr, groups)
+}
+
+// upgrade upgrades all installed packages
+func (m *microdnf) upgrade(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*dagger.Container, error) {
+	return ctr.WithExec([]string{"microdnf", "-y", "upgrade"}), nil
+}
+
+// clean removes any cached package metadata
+func (m *microdnf) clean(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*dagger.Container, error) {
+	return ctr.WithExec([]string{"microdnf", "clean", "all"}), nil
+}
+
+// groupsUnsupported returns an error if any package groups are given
+func (m *microdnf) groupsUnsupported(
+	ctr *dagger.Container,
+	groups []string,
+) (*dagger.Container, error) {
+	if len(groups) > 0 {
+		return nil, fmt.Errorf(
+			"unable to install or remove package groups %v: not supported by %s",
+			groups,
+			packageManagerMicrodnf,
+		)
+	}
+
+	return ctr, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	packageManagerDnf       = "dnf"
+	packageManagerDnf5      = "dnf5"
+	packageManagerRpmOstree = "rpm-ostree"
+	packageManagerMicrodnf  = "microdnf"
+)
+
+// detectedPackageManagers are the package managers detected by command, in
+// order of preference
+var detectedPackageManagers = []string{
+	packageManagerDnf5,
+	packageManagerDnf,
+	packageManagerMicrodnf,
+}
+
+// packageManagers are the supported package managers
+var packageManagers = append(
+	slices.Clone(detectedPackageManagers),
+	packageManagerRpmOstree,
+)
+
+// packageManager represents a package manager backend used to install and
+// remove packages on a Container
+//
+// Each operation is a no-op when given no packages or groups. Operations a
+// package manager cannot perform return an error.
+type packageManager interface {
+	// install the given packages
+	install(
+		ctx context.Context,
+		ctr *dagger.Container,
+		packages []string,
+	) (*dagger.Container, error)
+	// remove the given packages
+	remove(
+		ctx context.Context,
+		ctr *dagger.Container,
+		packages []string,
+	) (*dagger.Container, error)
+	// replace replaces installed (base image) packages with the given
+	// package versions, e.g. urls or paths of rpms
+	replace(
+		ctx context.Context,
+		ctr *dagger.Container,
+		packages []string,
+	) (*dagger.Container, error)
+	// swap removes and installs the given packages in one transaction
+	swap(
+		ctx context.Context,
+		ctr *dagger.Container,
+		remove, install string,
+	) (*dagger.Container, error)
+	// groupInstall installs the given package groups
+	groupInstall(
+		ctx context.Context,
+		ctr *dagger.Container,
+		groups []string,
+	) (*dagger.Container, error)
+	// groupRemove removes the given package groups
+	groupRemove(
+		ctx context.Context,
+		ctr *dagger.Container,
+		groups []string,
+	) (*dagger.Container, error)
+	// upgrade upgrades all installed packages
+	upgrade(ctx context.Context, ctr *dagger.Container) (*dagger.Container, error)
+	// clean removes any cached package metadata
+	clean(ctx context.Context, ctr *dagger.Container) (*dagger.Container, error)
+}
+
+// transactionalPackageManager is implemented by package managers able to
+// remove and install packages in one transaction, allowing for replacing
+// required packages with alternatives
+type transactionalPackageManager interface {
+	removeAndInstall(
+		ctx context.Context,
+		ctr *dagger.Container,
+		remove []string,
+		install []string,
+	) (*dagger.Container, error)
+}
+
+// packageManager returns the package manager backend set by
+// WithPackageManager or detected from the given Container
+func (f *Fedora) packageManager(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (packageManager, error) {
+	name := ""
+	if f.PackageManager != nil {
+		name = *f.PackageManager
+	} else {
+		var err error
+		name, err = detectPackageManager(ctx, ctr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch name {
+	case packageManagerDnf:
+		return dnf4, nil
+	case packageManagerDnf5:
+		return dnf5, nil
+	case packageManagerRpmOstree:
+		return &rpmOstree{releaseVersion: f.ReleaseVersion}, nil
+	case packageManagerMicrodnf:
+		return &microdnf{}, nil
+	}
+
+	return nil, fmt.Errorf(
+		"unsupported package manager '%s', must be one of: %s",
+		name,
+		strings.Join(packageManagers, ", "),
+	)
+}
+
+// detectPackageManager returns the package manager of the given Container:
+// rpm-ostree for ostree-based images (ostree.bootable label), including bootc
+// images, otherwise the first available of dnf5, dnf and microdnf (i.e.
+// minimal images)
+func detectPackageManager(ctx context.Context, ctr *dagger.Container) (string, error) {
+	if isOstreeBootable(ctx, ctr) {
+		return packageManagerRpmOstree, nil
+	}
+
+	script := fmt.Sprintf(
+		"for pm in %s; do command -v $pm >/dev/null && echo $pm && exit 0; done; exit 1",
+		strings.Join(detectedPackageManagers, " "),
+	)
+
+	ctr = ctr.WithExec(
+		[]string{"sh", "-c", script},
+		dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+	)
+	exitCode, err := ctr.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf(
+			"unable to detect package manager, none of: %s found",
+			strings.Join(detectedPackageManagers, ", "),
+		)
+	}
+
+	stdout, err := ctr.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
+
+// ctrWithCommand returns the given Container with the given command and
+// arguments executed, or unchanged if there are no arguments
+func ctrWithCommand(
+	ctr *dagger.Container,
+	command []string,
+	args []string,
+) *dagger.Container {
+	if len(args) == 0 {
+		return ctr
+	}
+
+	return ctr.WithExec(slices.Concat(command, args))
+}
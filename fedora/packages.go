@@ -5,6 +5,7 @@ import (
 	"dagger/fedora/internal/dagger"
 	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 const etcYumReposD = "/etc/yum.repos.d/"
@@ -14,6 +15,10 @@ type Repo struct {
 	Url      string
 	FileName string
 	Keep     bool
+	// repository file contents, if set the Url is not fetched
+	Contents string
+	// repository file, if set the Url is not fetched
+	File *dagger.File
 }
 
 // WithReposFromUrls will add the content at each given url and install them
@@ -42,7 +47,9 @@ func (f *Fedora) WithReposFromUrls(
 
 // WithPackageGroupsInstalled will install the given package groups
 //
-//	note: not supported on ostree-based images, will be skipped
+// ostree-based: groups are expanded to their mandatory & default packages
+//
+//	note: not supported by microdnf
 func (f *Fedora) WithPackageGroupsInstalled(
 	ctx context.Context,
 	// list of package groups to be installed
@@ -55,7 +62,9 @@ func (f *Fedora) WithPackageGroupsInstalled(
 
 // WithPackageGroupsRemoved will remove the given package groups
 //
-//	note: not supported on ostree-based images, will be skipped
+// ostree-based: groups are expanded to their mandatory & default packages
+//
+//	note: not supported by microdnf
 func (f *Fedora) WithPackageGroupsRemoved(
 	ctx context.Context,
 	// list of package groups to be installed
@@ -90,6 +99,55 @@ func (f *Fedora) WithPackagesRemoved(
 	return f
 }
 
+// WithLocalPackagesInstalled will install the given rpm files, and the rpm
+// files within the given directory, on the generated Container image. The
+// rpms are mounted, rather than copied into the image, while installed.
+func (f *Fedora) WithLocalPackagesInstalled(
+	ctx context.Context,
+	// rpm files to be installed
+	// +optional
+	files []*dagger.File,
+	// directory containing rpm files to be installed (source rpms are ignored)
+	// +optional
+	directory *dagger.Directory,
+) *Fedora {
+	f.LocalPackageFiles = append(f.LocalPackageFiles, files...)
+	if directory != nil {
+		f.LocalPackageDirectories = append(f.LocalPackageDirectories, directory)
+	}
+
+	return f
+}
+
+// WithPackageManager will force the package manager used to install and
+// remove packages, rather than detecting it from the base image, one of:
+// dnf (dnf4), dnf5, rpm-ostree, microdnf
+func (f *Fedora) WithPackageManager(
+	ctx context.Context,
+	// package manager to be used
+	packageManager string,
+) *Fedora {
+	f.PackageManager = &packageManager
+
+	return f
+}
+
+// WithPackagesReplaced will replace installed (base image) packages with the
+// given package versions, e.g. urls or paths of rpms
+// equivalent to:
+// `dnf install <packages>`
+// ostree-based (package specs are downloaded with `dnf download` first):
+// `rpm-ostree override replace <packages>`
+func (f *Fedora) WithPackagesReplaced(
+	ctx context.Context,
+	// list of packages to replace installed packages with
+	packages []string,
+) *Fedora {
+	f.PackagesReplaced = append(f.PackagesReplaced, packages...)
+
+	return f
+}
+
 type Swap struct {
 	Remove  string
 	Install string
@@ -100,6 +158,8 @@ type Swap struct {
 // `dnf swap <remove> <install>`
 // ostree-based:
 // `rpm-ostree override remove <remove> --install <install>`
+//
+//	note: not supported by microdnf
 func (f *Fedora) WithPackagesSwapped(
 	ctx context.Context,
 	// package to remove
@@ -121,9 +181,23 @@ func (f *Fedora) ctrWithReposInstalled(ctr *dagger.Container) (*dagger.Container
 	}
 
 	for _, r := range f.Repos {
-		contents, err := httpGet(r.Url)
-		if err != nil {
-			return nil, fmt.Errorf("error getting repo (%s) url: %w", r.FileName, err)
+		path := fmt.Sprintf("%s/%s", etcYumReposD, r.FileName)
+		if r.File != nil {
+			ctr = ctr.WithFile(path, r.File, dagger.ContainerWithFileOpts{
+				Permissions: 0644,
+				Owner:       "root:root",
+			})
+
+			continue
+		}
+
+		contents := []byte(r.Contents)
+		if r.Contents == "" {
+			var err error
+			contents, err = httpGet(r.Url)
+			if err != nil {
+				return nil, fmt.Errorf("error getting repo (%s) url: %w", r.FileName, err)
+			}
 		}
 
 		fileOpts := dagger.ContainerWithNewFileOpts{
@@ -131,7 +205,7 @@ func (f *Fedora) ctrWithReposInstalled(ctr *dagger.Container) (*dagger.Container
 			Owner:       "root:root",
 		}
 		ctr = ctr.WithNewFile(
-			fmt.Sprintf("%s/%s", etcYumReposD, r.FileName),
+			path,
 			string(contents),
 			fileOpts,
 		)
@@ -160,110 +234,168 @@ func (f *Fedora) ctrWithReposRemoved(ctr *dagger.Container) *dagger.Container {
 }
 
 // ctrWithPackagesInstalledAndRemoved executes installing and removing packages
-// as specified by the Fedora object
+// as specified by the Fedora object, using the package manager set by
+// WithPackageManager or detected from the Container
 func (f *Fedora) ctrWithPackagesInstalledAndRemoved(
 	ctx context.Context,
 	ctr *dagger.Container,
 ) (*dagger.Container, error) {
-	ostreeBootable, _ := ctr.Label(ctx, "ostree.bootable")
-	if ostreeBootable == "true" {
-		return f.ctrWithPackagesInstalledAndRemovedRpmOstree(ctx, ctr), nil
+	pm, err := f.packageManager(ctx, ctr)
+	if err != nil {
+		return nil, err
 	}
 
-	return f.ctrWithPackagesInstalledAndRemovedDnf(ctx, ctr), nil
-}
+	lock, err := f.packageLock(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-// ctrWithPackagesInstalledAndRemovedDnf executes installing and removing
-// packages using `dnf` as specified by the Fedora object
-func (f *Fedora) ctrWithPackagesInstalledAndRemovedDnf(
-	ctx context.Context,
-	ctr *dagger.Container,
-) *dagger.Container {
-	const dnf = "dnf"
+	packagesInstalled := f.PackagesInstalled
+	if lock != nil {
+		// the locked versions of the packages are installed by
+		// ctrWithPackageLock in place of the latest versions
+		if len(f.PackageGroupsInstalled) > 0 {
+			return nil, fmt.Errorf(
+				"package groups cannot be installed with a package lock, install the packages of %s with WithPackagesInstalled instead",
+				strings.Join(f.PackageGroupsInstalled, ", "),
+			)
+		}
+		if err := lock.validate(f.PackagesInstalled); err != nil {
+			return nil, err
+		}
+		packagesInstalled = nil
+	}
 
-	if len(f.PackageGroupsRemoved) > 0 {
-		ctr = f.ctrWithExec(ctr,
-			[]string{dnf, "-y", "group", "remove"},
-			f.PackageGroupsRemoved...,
-		)
+	ctr, err = pm.groupRemove(ctx, ctr, f.PackageGroupsRemoved)
+	if err != nil {
+		return nil, err
 	}
 
-	// dnf swap only supports two packages so remove & install must be done in
-	// separate transactions. https://bugzilla.redhat.com/show_bug.cgi?id=1934883
-	if len(f.PackagesRemoved) > 0 {
-		ctr = f.ctrWithExec(ctr,
-			[]string{dnf, "-y", "remove"},
-			f.PackagesRemoved...,
-		)
+	// Package managers able to do both in one transaction allow for replacing
+	// required packages with alternatives. dnf swap only supports two packages
+	// so remove & install must otherwise be done in separate transactions.
+	// https://bugzilla.redhat.com/show_bug.cgi?id=1934883
+	tpm, transactional := pm.(transactionalPackageManager)
+	removeAndInstall := transactional &&
+		len(f.PackagesRemoved) > 0 &&
+		len(packagesInstalled) > 0
+	if !removeAndInstall {
+		ctr, err = pm.remove(ctx, ctr, f.PackagesRemoved)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	packageGroupsInstalled := len(f.PackageGroupsInstalled) > 0
-	packagesInstalled := len(f.PackagesInstalled) > 0
-	if packageGroupsInstalled || packagesInstalled {
-		ctr = f.ctrWithExec(ctr,
-			[]string{dnf, "-y", "upgrade"},
-		)
+	if len(f.PackageGroupsInstalled) > 0 || len(packagesInstalled) > 0 {
+		ctr, err = pm.upgrade(ctx, ctr)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if packageGroupsInstalled {
-		ctr = f.ctrWithExec(ctr,
-			[]string{dnf, "-y", "group", "install"},
-			f.PackageGroupsInstalled...,
-		)
+	ctr, err = pm.groupInstall(ctx, ctr, f.PackageGroupsInstalled)
+	if err != nil {
+		return nil, err
 	}
 
-	if packagesInstalled {
-		ctr = f.ctrWithExec(ctr,
-			[]string{dnf, "-y", "install"},
-			f.PackagesInstalled...,
-		)
+	if removeAndInstall {
+		ctr, err = tpm.removeAndInstall(ctx, ctr, f.PackagesRemoved, packagesInstalled)
+	} else {
+		ctr, err = pm.install(ctx, ctr, packagesInstalled)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, err = f.ctrWithLocalPackagesInstalled(ctx, ctr, pm)
+	if err != nil {
+		return nil, err
+	}
+
+	ctr, err = pm.replace(ctx, ctr, f.PackagesReplaced)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, swap := range f.PackagesSwapped {
-		ctr = f.ctrWithExec(ctr,
-			[]string{dnf, "-y", "swap"},
-			swap.Remove, swap.Install,
-		)
+		ctr, err = pm.swap(ctx, ctr, swap.Remove, swap.Install)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return ctr.WithExec([]string{dnf, "clean", "all"})
+	if lock != nil {
+		ctr, err = ctrWithPackageLock(ctx, ctr, pm, lock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pm.clean(ctx, ctr)
 }
 
-// ctrWithPackagesInstalledAndRemoved will return the given Container with
-// packages installed and removed as defined by the Fedora object
-func (f *Fedora) ctrWithPackagesInstalledAndRemovedRpmOstree(
+// ctrWithLocalPackagesInstalled returns the given Container with the local rpm
+// files and directories installed with the given package manager. The rpms
+// are mounted for the install only.
+func (f *Fedora) ctrWithLocalPackagesInstalled(
 	ctx context.Context,
 	ctr *dagger.Container,
-) *dagger.Container {
-	removePackages := len(f.PackagesRemoved) > 0
-	installPackages := len(f.PackagesInstalled) > 0
-
-	cmd := []string{"rpm-ostree", "override", "remove"}
-	// Doing both actions in one command allows for replacing required
-	// packages with alternatives
-	if installPackages && removePackages {
-		cmd = append(cmd, f.PackagesRemoved...)
-		for _, p := range f.PackagesInstalled {
-			cmd = append(cmd, fmt.Sprintf("--install=%s", p))
+	pm packageManager,
+) (*dagger.Container, error) {
+	const localPackagesPath = "/tmp/local-packages"
+
+	mounts := []string{}
+	packages := []string{}
+	for i, file := range f.LocalPackageFiles {
+		name, err := file.Name(ctx)
+		if err != nil {
+			return nil, err
 		}
 
-		return f.ctrWithExec(ctr, cmd)
+		// files are mounted in their own directories to allow duplicate names
+		path := fmt.Sprintf("%s/file-%d/%s", localPackagesPath, i, name)
+		ctr = ctr.WithMountedFile(path, file)
+		mounts = append(mounts, path)
+		packages = append(packages, path)
+	}
 
-	} else if removePackages {
-		return f.ctrWithExec(ctr,
-			cmd,
-			f.PackagesRemoved...,
-		)
-	} else if installPackages {
-		return f.ctrWithExec(ctr,
-			[]string{"rpm-ostree", "install"},
-			f.PackagesInstalled...,
-		)
+	for i, dir := range f.LocalPackageDirectories {
+		globbed, err := dir.Glob(ctx, "**/*.rpm")
+		if err != nil {
+			return nil, err
+		}
+
+		// source rpms (i.e. built alongside binary rpms) are not installable
+		rpms := []string{}
+		for _, rpm := range globbed {
+			if !strings.HasSuffix(rpm, ".src.rpm") {
+				rpms = append(rpms, rpm)
+			}
+		}
+		if len(rpms) == 0 {
+			return nil, fmt.Errorf("no binary rpm files found in local packages directory")
+		}
+
+		path := fmt.Sprintf("%s/dir-%d", localPackagesPath, i)
+		ctr = ctr.WithMountedDirectory(path, dir)
+		mounts = append(mounts, path)
+		for _, rpm := range rpms {
+			packages = append(packages, fmt.Sprintf("%s/%s", path, rpm))
+		}
 	}
 
-	for _, swap := range f.PackagesSwapped {
-		ctr = f.ctrWithExec(ctr, cmd, swap.Remove, "--install", swap.Install)
+	if len(packages) == 0 {
+		return ctr, nil
 	}
 
-	return ctr
+	ctr, err := pm.install(ctx, ctr, packages)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range mounts {
+		ctr = ctr.WithoutMount(m)
+	}
+
+	return ctr.WithExec([]string{"rm", "-rf", localPackagesPath}), nil
 }
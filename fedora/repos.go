@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const coprBaseUrl = "https://download.copr.fedorainfracloud.org/results"
+
+// repoDefinition represents the options of a yum repository, rendered to a
+// .repo file
+//
+// See https://dnf.readthedocs.io/en/latest/conf_ref.html#repo-options
+type repoDefinition struct {
+	id                string
+	name              string
+	baseUrls          []string
+	metalink          string
+	gpgKeys           []string
+	gpgCheck          bool
+	priority          int
+	exclude           []string
+	includePkgs       []string
+	enabled           bool
+	moduleHotfixes    bool
+	skipIfUnavailable bool
+}
+
+// validate returns an error if the repository definition is incomplete
+func (r *repoDefinition) validate() error {
+	if r.id == "" || strings.ContainsAny(r.id, " /[]") {
+		return fmt.Errorf("invalid repository id '%s'", r.id)
+	}
+
+	if len(r.baseUrls) == 0 && r.metalink == "" {
+		return fmt.Errorf("repository '%s' requires one of: baseurl, metalink", r.id)
+	}
+
+	if r.priority < 0 || r.priority > 99 {
+		return fmt.Errorf(
+			"invalid repository '%s' priority %d, must be between 1 and 99",
+			r.id,
+			r.priority,
+		)
+	}
+
+	return nil
+}
+
+// render returns the .repo file contents of the repository definition
+func (r *repoDefinition) render() string {
+	var sb strings.Builder
+	option := func(key string, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s=%s\n", key, value)
+		}
+	}
+	boolOption := func(key string, value bool) {
+		if value {
+			option(key, "1")
+		} else {
+			option(key, "0")
+		}
+	}
+
+	fmt.Fprintf(&sb, "[%s]\n", r.id)
+	name := r.name
+	if name == "" {
+		name = r.id
+	}
+	option("name", name)
+	option("baseurl", strings.Join(r.baseUrls, ","))
+	option("metalink", r.metalink)
+	option("gpgkey", strings.Join(r.gpgKeys, ","))
+	boolOption("gpgcheck", r.gpgCheck)
+	if r.priority > 0 {
+		option("priority", fmt.Sprintf("%d", r.priority))
+	}
+	option("exclude", strings.Join(r.exclude, ","))
+	option("includepkgs", strings.Join(r.includePkgs, ","))
+	boolOption("enabled", r.enabled)
+	if r.moduleHotfixes {
+		boolOption("module_hotfixes", r.moduleHotfixes)
+	}
+	if r.skipIfUnavailable {
+		boolOption("skip_if_unavailable", r.skipIfUnavailable)
+	}
+
+	return sb.String()
+}
+
+// withRepoDefinition adds the given repository definition to be installed on
+// the Container image as the given file name
+func (f *Fedora) withRepoDefinition(
+	repo *repoDefinition,
+	fileName string,
+	keep bool,
+) (*Fedora, error) {
+	if err := repo.validate(); err != nil {
+		return nil, err
+	}
+
+	f.Repos = append(f.Repos, &Repo{
+		FileName: fileName,
+		Keep:     keep,
+		Contents: repo.render(),
+	})
+
+	return f, nil
+}
+
+// WithRepo will add a yum repository, defined by the given options, and
+// install it on the Container image prior to package installation via
+// WithPackagesInstalled. Optionally removing the repository afterward, prior
+// to exporting the container.
+//
+// See https://dnf.readthedocs.io/en/latest/conf_ref.html#repo-options
+func (f *Fedora) WithRepo(
+	ctx context.Context,
+	// repository id, also used as the repository file name (<id>.repo)
+	id string,
+	// repository name
+	// +optional
+	name string,
+	// urls of the repository, one of baseUrl or metalink is required
+	// +optional
+	baseUrl []string,
+	// metalink url of the repository
+	// +optional
+	metalink string,
+	// urls of the GPG keys packages are signed with
+	// +optional
+	gpgKey []string,
+	// If true, package GPG signatures will be checked
+	// +optional
+	// +default=true
+	gpgCheck bool,
+	// priority of the repository (1-99, lower is preferred)
+	// +optional
+	priority int,
+	// packages to exclude from the repository
+	// +optional
+	exclude []string,
+	// packages to include from the repository, excluding all others
+	// +optional
+	includePkgs []string,
+	// If true, the repository will be enabled
+	// +optional
+	// +default=true
+	enabled bool,
+	// If true, packages of the repository are not filtered by modularity
+	// +optional
+	moduleHotfixes bool,
+	// If true, the repository is skipped if unavailable
+	// +optional
+	skipIfUnavailable bool,
+	// If true, the repository will not be removed on the generated Container
+	// image
+	// +optional
+	keep bool,
+) (*Fedora, error) {
+	return f.withRepoDefinition(&repoDefinition{
+		id:                id,
+		name:              name,
+		baseUrls:          baseUrl,
+		metalink:          metalink,
+		gpgKeys:           gpgKey,
+		gpgCheck:          gpgCheck,
+		priority:          priority,
+		exclude:           exclude,
+		includePkgs:       includePkgs,
+		enabled:           enabled,
+		moduleHotfixes:    moduleHotfixes,
+		skipIfUnavailable: skipIfUnavailable,
+	}, fmt.Sprintf("%s.repo", id), keep)
+}
+
+// WithRepoFile will install the given yum repository (.repo) file on the
+// Container image prior to package installation via WithPackagesInstalled.
+// Optionally removing the repository afterward, prior to exporting the
+// container.
+func (f *Fedora) WithRepoFile(
+	ctx context.Context,
+	// yum repository (.repo) file
+	file *dagger.File,
+	// If true, the repository will not be removed on the generated Container
+	// image
+	// +optional
+	keep bool,
+) (*Fedora, error) {
+	fileName, err := file.Name(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(fileName, ".repo") {
+		return nil, fmt.Errorf("repository file '%s' must have a .repo extension", fileName)
+	}
+
+	f.Repos = append(f.Repos, &Repo{
+		FileName: fileName,
+		Keep:     keep,
+		File:     file,
+	})
+
+	return f, nil
+}
+
+// WithCoprRepos will add the given COPR repositories for the release version
+// of the Container image, and the architecture it is built for, prior to
+// package installation via WithPackagesInstalled. Optionally removing the
+// repositories afterward, prior to exporting the container.
+//
+//	example: owner/project, @group/project
+func (f *Fedora) WithCoprRepos(
+	ctx context.Context,
+	// COPR repositories (owner/project) to install
+	repos []string,
+	// If true, the repositories will not be removed on the generated
+	// Container image
+	keep bool,
+	// COPR results base url, for alternative COPR instances
+	// +optional
+	// +default="https://download.copr.fedorainfracloud.org/results"
+	baseUrl string,
+) (*Fedora, error) {
+	if baseUrl == "" {
+		baseUrl = coprBaseUrl
+	}
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing COPR base url '%s': %w", baseUrl, err)
+	}
+	host := strings.TrimPrefix(u.Hostname(), "download.")
+
+	releaseVersion := "$releasever"
+	if f.ReleaseVersion != nil {
+		releaseVersion = *f.ReleaseVersion
+	}
+
+	for _, r := range repos {
+		owner, project, found := strings.Cut(r, "/")
+		if !found || owner == "" || project == "" || strings.Contains(project, "/") {
+			return nil, fmt.Errorf(
+				"invalid COPR repository '%s', must be in the form: owner/project",
+				r,
+			)
+		}
+
+		// group owners (@group) are identified as group_<group>
+		id := fmt.Sprintf(
+			"copr:%s:%s:%s",
+			host,
+			strings.Replace(owner, "@", "group_", 1),
+			project,
+		)
+		results := fmt.Sprintf("%s/%s/%s", baseUrl, owner, project)
+
+		// named as the dnf copr plugin does
+		f, err = f.withRepoDefinition(&repoDefinition{
+			id:   id,
+			name: fmt.Sprintf("Copr repo for %s owned by %s", project, owner),
+			baseUrls: []string{
+				fmt.Sprintf("%s/fedora-%s-$basearch/", results, releaseVersion),
+			},
+			gpgKeys:           []string{fmt.Sprintf("%s/pubkey.gpg", results)},
+			gpgCheck:          true,
+			enabled:           true,
+			skipIfUnavailable: true,
+		}, fmt.Sprintf("_%s.repo", id), keep)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
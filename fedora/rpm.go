@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// rpm query format of rpmPackage fields, tab separated
+const rpmQueryFormat = "%{NAME}\t%{EPOCHNUM}\t%{VERSION}\t%{RELEASE}\t%{ARCH}\t%{LICENSE}\n"
+
+// dnf repoquery of the repository each installed package was installed from,
+// if dnf is available
+const rpmReposScript = `if command -v dnf5 >/dev/null; then
+  dnf5 -q repoquery --installed --qf '%{name}.%{arch} %{from_repo}\n'
+elif command -v dnf >/dev/null; then
+  dnf -q repoquery --installed --qf '%{name}.%{arch} %{from_repo}'
+fi`
+
+// rpmPackage represents an installed rpm package
+type rpmPackage struct {
+	Name    string `json:"name"`
+	Epoch   string `json:"epoch"`
+	Version string `json:"version"`
+	Release string `json:"release"`
+	Arch    string `json:"arch"`
+	License string `json:"license,omitempty"`
+	// repository the package was installed from, if known
+	Repo string `json:"repo,omitempty"`
+}
+
+// key returns the name.arch of the package, identifying it amongst installed
+// packages
+func (p *rpmPackage) key() string {
+	return fmt.Sprintf("%s.%s", p.Name, p.Arch)
+}
+
+// evr returns the [epoch:]version-release of the package
+func (p *rpmPackage) evr() string {
+	if p.Epoch == "" || p.Epoch == "0" {
+		return fmt.Sprintf("%s-%s", p.Version, p.Release)
+	}
+
+	return fmt.Sprintf("%s:%s-%s", p.Epoch, p.Version, p.Release)
+}
+
+// nevra returns the name-[epoch:]version-release.arch of the package
+func (p *rpmPackage) nevra() string {
+	return fmt.Sprintf("%s-%s.%s", p.Name, p.evr(), p.Arch)
+}
+
+// rpmPackages returns the packages installed on the given Container, from
+// its rpm database, sorted by name
+func rpmPackages(ctx context.Context, ctr *dagger.Container) ([]*rpmPackage, error) {
+	stdout, err := ctr.
+		WithExec([]string{"rpm", "-qa", "--qf", rpmQueryFormat}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error querying rpm database: %w", err)
+	}
+
+	packages := []*rpmPackage{}
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(line, "\t")
+		// gpg-pubkey entries are imported keys rather than packages
+		if len(fields) < 6 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+
+		packages = append(packages, &rpmPackage{
+			Name:    fields[0],
+			Epoch:   fields[1],
+			Version: fields[2],
+			Release: fields[3],
+			Arch:    fields[4],
+			License: fields[5],
+		})
+	}
+
+	slices.SortFunc(packages, func(a, b *rpmPackage) int {
+		return strings.Compare(a.key(), b.key())
+	})
+
+	return packages, nil
+}
+
+// withRpmRepos sets the repository the given packages were installed from,
+// as known by dnf on the given Container. Repositories are left unset if dnf
+// is not available (e.g. ostree-based and minimal images).
+func withRpmRepos(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []*rpmPackage,
+) error {
+	stdout, err := ctr.
+		WithExec(
+			[]string{"sh", "-c", rpmReposScript},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+
+	repos := map[string]string{}
+	for _, line := range strings.Split(stdout, "\n") {
+		if key, repo, found := strings.Cut(strings.TrimSpace(line), " "); found {
+			repos[key] = repo
+		}
+	}
+
+	for _, p := range packages {
+		p.Repo = repos[p.key()]
+	}
+
+	return nil
+}
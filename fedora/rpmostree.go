@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"dagger/fedora/internal/dagger"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// image package groups of ostree-based images are expanded, and package
+// versions to replace downloaded, with
+const dnfImage = "registry.fedoraproject.org/fedora"
+
+// rpmOstree represents the rpm-ostree package manager of ostree-based images
+// (e.g. Fedora Atomic Desktops, CoreOS)
+//
+// rpm-ostree has no notion of package groups, groups are expanded to their
+// mandatory & default packages with `dnf group info` from a Fedora container
+// of the same release version and the repositories of the image. Package
+// versions to replace are likewise downloaded with `dnf download`.
+type rpmOstree struct {
+	// release version of the image, required to expand package groups
+	releaseVersion *string
+	// dnf binary packages were upgraded with, if any, to clean up after
+	upgradedWith string
+}
+
+// install the given packages
+func (r *rpmOstree) install(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, []string{"rpm-ostree", "install"}, packages), nil
+}
+
+// remove the given (base image) packages
+func (r *rpmOstree) remove(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	return ctrWithCommand(ctr, []string{"rpm-ostree", "override", "remove"}, packages), nil
+}
+
+// removeAndInstall removes the given (base image) packages and installs the
+// given packages in one transaction
+func (r *rpmOstree) removeAndInstall(
+	ctx context.Context,
+	ctr *dagger.Container,
+	remove []string,
+	install []string,
+) (*dagger.Container, error) {
+	args := append([]string{}, remove...)
+	for _, p := range install {
+		args = append(args, fmt.Sprintf("--install=%s", p))
+	}
+
+	return r.remove(ctx, ctr, args)
+}
+
+// replace replaces base image packages with the given package versions. Urls
+// or paths of rpms are replaced with as-is, while package specs (e.g. nevra
+// of a package lock), unsupported by `rpm-ostree override replace`, are
+// downloaded first.
+func (r *rpmOstree) replace(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) (*dagger.Container, error) {
+	const downloadedPackagesPath = "/tmp/replaced-packages"
+
+	rpms, specs := []string{}, []string{}
+	for _, p := range packages {
+		if strings.Contains(p, "/") {
+			rpms = append(rpms, p)
+		} else {
+			specs = append(specs, p)
+		}
+	}
+	if len(specs) == 0 {
+		return ctrWithCommand(ctr, []string{"rpm-ostree", "override", "replace"}, rpms), nil
+	}
+
+	downloaded, err := r.downloadPackages(ctx, ctr, specs)
+	if err != nil {
+		return nil, err
+	}
+	names, err := downloaded.Glob(ctx, "*.rpm")
+	if err != nil {
+		return nil, fmt.Errorf("error downloading packages %v: %w", specs, err)
+	}
+	for _, name := range names {
+		rpms = append(rpms, fmt.Sprintf("%s/%s", downloadedPackagesPath, name))
+	}
+
+	return ctrWithCommand(
+		ctr.WithMountedDirectory(downloadedPackagesPath, downloaded),
+		[]string{"rpm-ostree", "override", "replace"},
+		rpms,
+	).WithoutMount(downloadedPackagesPath), nil
+}
+
+// swap removes and installs the given packages in one transaction
+func (r *rpmOstree) swap(
+	ctx context.Context,
+	ctr *dagger.Container,
+	remove, install string,
+) (*dagger.Container, error) {
+	return r.removeAndInstall(ctx, ctr, []string{remove}, []string{install})
+}
+
+// groupInstall installs the packages of the given package groups
+func (r *rpmOstree) groupInstall(
+	ctx context.Context,
+	ctr *dagger.Container,
+	groups []string,
+) (*dagger.Container, error) {
+	if len(groups) == 0 {
+		return ctr, nil
+	}
+
+	packages, err := r.groupPackages(ctx, ctr, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	// packages of the group already in the base image are kept as they are
+	installed, err := installedPackages(ctx, ctr, packages)
+	if err != nil {
+		return nil, err
+	}
+	packages = slices.DeleteFunc(packages, func(p string) bool {
+		return slices.Contains(installed, p)
+	})
+
+	return r.install(ctx, ctr, packages)
+}
+
+// groupRemove removes the (installed) packages of the given package groups
+func (r *rpmOstree) groupRemove(
+	ctx context.Context,
+	ctr *dagger.Container,
+	groups []string,
+) (*dagger.Container, error) {
+	if len(groups) == 0 {
+		return ctr, nil
+	}
+
+	packages, err := r.groupPackages(ctx, ctr, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := installedPackages(ctx, ctr, packages)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.remove(ctx, ctr, installed)
+}
+
+// upgrade upgrades all installed packages with dnf, as rpm-ostree cannot
+// upgrade within a container build. The upgrade is skipped, with a warning,
+// if the image does not ship with dnf (i.e. CoreOS, Atomic Desktops prior to
+// Fedora 41) so packages are still installed with rpm-ostree.
+func (r *rpmOstree) upgrade(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*dagger.Container, error) {
+	dnf, err := ctr.
+		WithExec(
+			[]string{"sh", "-c", "command -v dnf5 || command -v dnf"},
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dnf = strings.TrimSpace(dnf)
+	if dnf == "" {
+		fmt.Fprintf(
+			os.Stderr,
+			"warning: skipping package upgrade with %s: dnf not found in the image\n",
+			packageManagerRpmOstree,
+		)
+		return ctr, nil
+	}
+
+	r.upgradedWith = dnf
+	return ctr.WithExec([]string{dnf, "-y", "upgrade"}), nil
+}
+
+// clean removes the dnf cache & logs if packages were upgraded with dnf,
+// rpm-ostree itself does not cache package metadata in the image
+func (r *rpmOstree) clean(
+	ctx context.Context,
+	ctr *dagger.Container,
+) (*dagger.Container, error) {
+	if r.upgradedWith == "" {
+		return ctr, nil
+	}
+
+	return ctr.
+		WithExec([]string{r.upgradedWith, "clean", "all"}).
+		WithExec([]string{
+			"sh", "-c", "rm -rf /var/log/dnf*.log* /var/log/hawkey.log*",
+		}), nil
+}
+
+// groupPackages returns the mandatory & default packages of the given package
+// groups as listed by `dnf group info` in a Fedora container of the same
+// release version, using the repositories of the given Container
+func (r *rpmOstree) groupPackages(
+	ctx context.Context,
+	ctr *dagger.Container,
+	groups []string,
+) ([]string, error) {
+	if r.releaseVersion == nil {
+		return nil, fmt.Errorf(
+			"unable to expand package groups %v: release version of the base image unknown",
+			groups,
+		)
+	}
+
+	const rpmGpgKeys = "/etc/pki/rpm-gpg"
+	stdout, err := dag.
+		Container().
+		From(fmt.Sprintf("%s:%s", dnfImage, *r.releaseVersion)).
+		WithDirectory(etcYumReposD, ctr.Directory(etcYumReposD)).
+		WithDirectory(rpmGpgKeys, ctr.Directory(rpmGpgKeys)).
+		WithExec(append([]string{"dnf", "-q", "group", "info"}, groups...)).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding package groups %v: %w", groups, err)
+	}
+
+	packages := parseGroupInfo(stdout)
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no packages found in package groups %v", groups)
+	}
+
+	return packages, nil
+}
+
+// downloadPackages returns a directory of the rpms of the given package specs
+// downloaded with `dnf download` in a Fedora container of the same release
+// version, using the repositories of the given Container
+func (r *rpmOstree) downloadPackages(
+	ctx context.Context,
+	ctr *dagger.Container,
+	specs []string,
+) (*dagger.Directory, error) {
+	if r.releaseVersion == nil {
+		return nil, fmt.Errorf(
+			"unable to download packages %v: release version of the base image unknown",
+			specs,
+		)
+	}
+
+	const (
+		rpmGpgKeys   = "/etc/pki/rpm-gpg"
+		downloadPath = "/tmp/packages"
+	)
+	downloaded := dag.
+		Container().
+		From(fmt.Sprintf("%s:%s", dnfImage, *r.releaseVersion)).
+		WithDirectory(etcYumReposD, ctr.Directory(etcYumReposD)).
+		WithDirectory(rpmGpgKeys, ctr.Directory(rpmGpgKeys)).
+		WithExec(append(
+			[]string{"dnf", "-q", "download", fmt.Sprintf("--destdir=%s", downloadPath)},
+			specs...,
+		)).
+		Directory(downloadPath)
+
+	return downloaded, nil
+}
+
+// parseGroupInfo returns the mandatory & default packages from the given
+// `dnf group info` output, of either dnf4:
+//
+//	Mandatory Packages:
+//	   gettext
+//
+// or dnf5:
+//
+//	Mandatory packages   : gettext
+//	                     : make
+func parseGroupInfo(output string) []string {
+	packages := []string{}
+	include := false
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			value = line
+		} else if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			include = strings.HasPrefix(key, "mandatory packages") ||
+				strings.HasPrefix(key, "default packages")
+		}
+
+		name := strings.TrimLeft(strings.TrimSpace(value), "=+-")
+		if include && name != "" && !slices.Contains(packages, name) {
+			packages = append(packages, name)
+		}
+	}
+
+	return packages
+}
+
+// installedPackages returns which of the given packages are installed on the
+// given Container
+func installedPackages(
+	ctx context.Context,
+	ctr *dagger.Container,
+	packages []string,
+) ([]string, error) {
+	stdout, err := ctr.
+		WithExec(
+			append([]string{"rpm", "-q", "--qf", "%{NAME}\n"}, packages...),
+			dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny},
+		).
+		Stdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	installed := []string{}
+	for _, line := range strings.Split(stdout, "\n") {
+		// not installed packages are reported as: package <name> is not installed
+		if name := strings.TrimSpace(line); name != "" && !strings.Contains(name, " ") {
+			installed = append(installed, name)
+		}
+	}
+
+	return installed, nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"dagger/fedora/internal/dagger"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	sbomFormatSpdx      = "spdx"
+	sbomFormatCycloneDx = "cyclonedx"
+
+	sbomCreator     = "Tool: daggerverse-fedora"
+	sbomNoAssertion = "NOASSERTION"
+)
+
+var (
+	// spdxIdRegexp matches SPDX license & exception identifiers, optionally
+	// followed by the "or later" operator
+	spdxIdRegexp = regexp.MustCompile(`^(?:LicenseRef-[A-Za-z0-9.-]+|[A-Za-z0-9][A-Za-z0-9.-]*\+?)$`)
+	// spdxLegacyIdRegexp matches legacy Fedora license short names (e.g. GPLv2+,
+	// ASL, BSD) which are no SPDX license identifiers
+	spdxLegacyIdRegexp = regexp.MustCompile(`v\d|^(?:A?GPL|LGPL|ASL|BSD|MPL|Boost|Python)\+?$`)
+)
+
+// spdxDocument represents an SPDX 2.3 JSON document
+//
+// See https://spdx.github.io/spdx-spec/v2.3/
+type spdxDocument struct {
+	SpdxVersion       string              `json:"spdxVersion"`
+	DataLicense       string              `json:"dataLicense"`
+	SpdxId            string              `json:"SPDXID"`
+	Name              string              `json:"name"`
+	DocumentNamespace string              `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo    `json:"creationInfo"`
+	Packages          []*spdxPackage      `json:"packages"`
+	Relationships     []*spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string             `json:"name"`
+	SpdxId           string             `json:"SPDXID"`
+	VersionInfo      string             `json:"versionInfo"`
+	DownloadLocation string             `json:"downloadLocation"`
+	FilesAnalyzed    bool               `json:"filesAnalyzed"`
+	LicenseConcluded string             `json:"licenseConcluded"`
+	LicenseDeclared  string             `json:"licenseDeclared"`
+	CopyrightText    string             `json:"copyrightText"`
+	ExternalRefs     []*spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SpdxElementId      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+// cycloneDxDocument represents a CycloneDX 1.5 JSON document
+//
+// See https://cyclonedx.org/docs/1.5/json/
+type cycloneDxDocument struct {
+	BomFormat   string                `json:"bomFormat"`
+	SpecVersion string                `json:"specVersion"`
+	Version     int                   `json:"version"`
+	Metadata    cycloneDxMetadata     `json:"metadata"`
+	Components  []*cycloneDxComponent `json:"components"`
+}
+
+type cycloneDxMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Component cycloneDxComponent `json:"component"`
+}
+
+type cycloneDxComponent struct {
+	Type       string              `json:"type"`
+	BomRef     string              `json:"bom-ref,omitempty"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	Purl       string              `json:"purl,omitempty"`
+	Licenses   []*cycloneDxLicense `json:"licenses,omitempty"`
+	Properties []*cycloneDxProp    `json:"properties,omitempty"`
+}
+
+type cycloneDxLicense struct {
+	Expression string                `json:"expression,omitempty"`
+	License    *cycloneDxLicenseName `json:"license,omitempty"`
+}
+
+type cycloneDxLicenseName struct {
+	Name string `json:"name"`
+}
+
+type cycloneDxProp struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Sbom returns an SBOM of the packages installed on the generated Container
+// image, from the rpm database of the image, as a SPDX (2.3) or CycloneDX
+// (1.5) JSON file
+func (f *Fedora) Sbom(
+	ctx context.Context,
+	// SBOM format, one of: spdx, cyclonedx
+	// +optional
+	// +default="spdx"
+	format string,
+) (*dagger.File, error) {
+	if format != sbomFormatSpdx && format != sbomFormatCycloneDx {
+		return nil, fmt.Errorf(
+			"unsupported SBOM format '%s', must be one of: %s, %s",
+			format,
+			sbomFormatSpdx,
+			sbomFormatCycloneDx,
+		)
+	}
+
+	packages, err := f.containerPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := f.ContainerAddress(f.Registry, f.Org, f.Variant, f.Suffix, f.Tag)
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	var doc any
+	fileName := "sbom.spdx.json"
+	switch format {
+	case sbomFormatSpdx:
+		doc = f.spdxDocument(name, created, packages)
+	case sbomFormatCycloneDx:
+		doc = f.cycloneDxDocument(name, created, packages)
+		fileName = "sbom.cdx.json"
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error rendering %s SBOM: %w", format, err)
+	}
+
+	return dag.File(fileName, string(data)), nil
+}
+
+// spdxDocument returns the SPDX document of the given image name & packages
+func (f *Fedora) spdxDocument(
+	name string,
+	created string,
+	packages []*rpmPackage,
+) *spdxDocument {
+	doc := &spdxDocument{
+		SpdxVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SpdxId:      "SPDXRef-DOCUMENT",
+		Name:        name,
+		DocumentNamespace: fmt.Sprintf(
+			"https://spdx.org/spdxdocs/%s-%s",
+			url.PathEscape(name),
+			packagesDigest(packages),
+		),
+		CreationInfo: spdxCreationInfo{
+			Created:  created,
+			Creators: []string{sbomCreator},
+		},
+		Packages:      []*spdxPackage{},
+		Relationships: []*spdxRelationship{},
+	}
+
+	for i, p := range packages {
+		license := p.License
+		if !spdxExpression(license) {
+			license = sbomNoAssertion
+		}
+
+		id := fmt.Sprintf("SPDXRef-Package-rpm-%d", i)
+		doc.Packages = append(doc.Packages, &spdxPackage{
+			Name:             p.Name,
+			SpdxId:           id,
+			VersionInfo:      fmt.Sprintf("%s-%s", p.Version, p.Release),
+			DownloadLocation: sbomNoAssertion,
+			LicenseConcluded: sbomNoAssertion,
+			LicenseDeclared:  license,
+			CopyrightText:    sbomNoAssertion,
+			ExternalRefs: []*spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  f.purl(p),
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, &spdxRelationship{
+			SpdxElementId:      doc.SpdxId,
+			RelationshipType:   "DESCRIBES",
+			RelatedSpdxElement: id,
+		})
+	}
+
+	return doc
+}
+
+// cycloneDxDocument returns the CycloneDX document of the given image name &
+// packages
+func (f *Fedora) cycloneDxDocument(
+	name string,
+	created string,
+	packages []*rpmPackage,
+) *cycloneDxDocument {
+	doc := &cycloneDxDocument{
+		BomFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cycloneDxMetadata{
+			Timestamp: created,
+			Component: cycloneDxComponent{Type: "container", Name: name},
+		},
+		Components: []*cycloneDxComponent{},
+	}
+
+	for _, p := range packages {
+		purl := f.purl(p)
+		component := &cycloneDxComponent{
+			Type:    "library",
+			BomRef:  purl,
+			Name:    p.Name,
+			Version: fmt.Sprintf("%s-%s", p.Version, p.Release),
+			Purl:    purl,
+		}
+		if spdxExpression(p.License) {
+			component.Licenses = []*cycloneDxLicense{{Expression: p.License}}
+		} else if p.License != "" {
+			component.Licenses = []*cycloneDxLicense{{
+				License: &cycloneDxLicenseName{Name: p.License},
+			}}
+		}
+		if p.Repo != "" {
+			component.Properties = []*cycloneDxProp{{Name: "rpm:repo", Value: p.Repo}}
+		}
+
+		doc.Components = append(doc.Components, component)
+	}
+
+	return doc
+}
+
+// spdxExpression returns whether the given license is a valid SPDX license
+// expression, rpm packages built before Fedora adopted SPDX declare legacy
+// license short names (e.g. "GPLv2+ or Artistic")
+//
+// See https://spdx.github.io/spdx-spec/v2.3/SPDX-license-expressions/
+func spdxExpression(license string) bool {
+	tokens := strings.Fields(
+		strings.NewReplacer("(", " ( ", ")", " ) ").Replace(license),
+	)
+	if len(tokens) == 0 {
+		return false
+	}
+
+	p := &spdxParser{tokens: tokens}
+	return p.compound() && p.pos == len(tokens)
+}
+
+// spdxParser is a recursive descent parser of SPDX license expressions
+type spdxParser struct {
+	tokens []string
+	pos    int
+}
+
+// next returns the next token, or an empty string at the end of the
+// expression
+func (p *spdxParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+// compound parses an expression of simple expressions joined with AND or OR
+func (p *spdxParser) compound() bool {
+	if !p.simple() {
+		return false
+	}
+	for p.next() == "AND" || p.next() == "OR" {
+		p.pos++
+		if !p.simple() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// simple parses a parenthesized expression or a license identifier,
+// optionally followed by WITH and an exception identifier
+func (p *spdxParser) simple() bool {
+	if p.next() == "(" {
+		p.pos++
+		if !p.compound() || p.next() != ")" {
+			return false
+		}
+		p.pos++
+		return true
+	}
+
+	if !p.identifier() {
+		return false
+	}
+	if p.next() == "WITH" {
+		p.pos++
+		return p.identifier()
+	}
+
+	return true
+}
+
+// identifier parses a license or exception identifier
+func (p *spdxParser) identifier() bool {
+	id := p.next()
+	switch id {
+	case "", "(", ")", "AND", "OR", "WITH":
+		return false
+	}
+	if !spdxIdRegexp.MatchString(id) || spdxLegacyIdRegexp.MatchString(id) {
+		return false
+	}
+	p.pos++
+
+	return true
+}
+
+// purl returns the package URL of the given package
+//
+// See https://github.com/package-url/purl-spec
+func (f *Fedora) purl(p *rpmPackage) string {
+	qualifiers := url.Values{}
+	qualifiers.Set("arch", p.Arch)
+	if p.Epoch != "" && p.Epoch != "0" {
+		qualifiers.Set("epoch", p.Epoch)
+	}
+	if f.ReleaseVersion != nil {
+		qualifiers.Set("distro", fmt.Sprintf("fedora-%s", *f.ReleaseVersion))
+	}
+
+	return fmt.Sprintf(
+		"pkg:rpm/fedora/%s@%s-%s?%s",
+		url.PathEscape(p.Name),
+		url.PathEscape(p.Version),
+		url.PathEscape(p.Release),
+		qualifiers.Encode(),
+	)
+}
+
+// packagesDigest returns a digest identifying the given packages
+func packagesDigest(packages []*rpmPackage) string {
+	h := sha256.New()
+	for _, p := range packages {
+		fmt.Fprintln(h, p.nevra())
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
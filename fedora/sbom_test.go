@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestSpdxExpression(t *testing.T) {
+	tests := []struct {
+		license string
+		want    bool
+	}{
+		{license: "MIT", want: true},
+		{license: "GPL-2.0-or-later", want: true},
+		{license: "GPL-2.0+", want: true},
+		{license: "(MIT OR Apache-2.0) AND BSD-3-Clause", want: true},
+		{license: "GPL-2.0-or-later WITH Classpath-exception-2.0", want: true},
+		{license: "LGPL-2.1-or-later AND (GPL-3.0-or-later OR MIT)", want: true},
+		{license: "LicenseRef-Fedora-Public-Domain", want: true},
+		{license: "", want: false},
+		{license: "GPLv2+", want: false},
+		{license: "GPLv2+ or Artistic", want: false},
+		{license: "ASL 2.0", want: false},
+		{license: "BSD", want: false},
+		{license: "Public Domain", want: false},
+		{license: "Apache-2.0 and MIT", want: false},
+		{license: "MIT AND", want: false},
+		{license: "WITH Classpath-exception-2.0", want: false},
+		{license: "(MIT OR Apache-2.0", want: false},
+		{license: "MIT OR Apache-2.0)", want: false},
+		{license: "((MIT)", want: false},
+		{license: "()", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := spdxExpression(tt.license); got != tt.want {
+			t.Errorf("spdxExpression(%q) = %v, want %v", tt.license, got, tt.want)
+		}
+	}
+}